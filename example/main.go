@@ -21,19 +21,11 @@ func (m *IncrMiddleware) ServeHTTP(ctx context.Context, w http.ResponseWriter, r
 	return next(newCtx, w, r)
 }
 
-func AuthHandler(handler server.Handler) server.Handler {
-	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
-		userId, ok := ctx.Value("userId").(int)
-		if !ok {
-			userId = 1
-		}
-		ctx = context.WithValue(ctx, "userId", userId+1)
-		authed := true
-		if authed {
-			handler(ctx, w, r)
-		}
-		return ctx
+func CheckPassword(username, password string) (*server.Principal, bool) {
+	if username == "sweb" && server.SecureCompare(password, "secret") {
+		return &server.Principal{Subject: username, Roles: []string{"admin"}}, true
 	}
+	return nil, false
 }
 
 func Hello(ctx context.Context, w http.ResponseWriter, request *http.Request) context.Context {
@@ -43,15 +35,30 @@ func Hello(ctx context.Context, w http.ResponseWriter, request *http.Request) co
 	return ctx
 }
 
+func AuthHello(ctx context.Context, w http.ResponseWriter, request *http.Request) context.Context {
+	principal, _ := server.PrincipalFrom(ctx)
+	fmt.Fprintf(w, "Hello, %q, authenticated as %s", server.Params(ctx, "name"), principal.Subject)
+	return ctx
+}
+
 func main() {
 	ctx := context.WithValue(context.Background(), "userId", 1)
 	srv := server.New(ctx, true)
 
+	proxyWare, err := server.NewProxyWare(server.ProxyOptions{TrustedProxies: []string{"127.0.0.1"}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.Middleware(proxyWare)
 	srv.Middleware(server.NewRecoveryWare(true))
 	srv.Middleware(server.NewStatWare())
 	srv.Middleware(&IncrMiddleware{})
+	srv.Middleware(server.NewAuthWare(server.AuthOptions{
+		Authenticators: []server.Authenticator{server.BasicAuth("sweb", CheckPassword)},
+		Public:         []string{"Hello"},
+	}))
 	srv.Get("/hello/:name", "Hello", Hello)
-	srv.Get("/auth/:name", "AuthHello", AuthHandler(Hello))
+	srv.Get("/auth/:name", "AuthHello", AuthHello)
 
 	log.Fatal(srv.Run(":9000"))
 }
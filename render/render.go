@@ -30,6 +30,15 @@ Package render provides html/templates rendering for the composable templates wh
 	// or we can render some json data
 	err := r.Json(w, http.StatusOK, "Hello World")
 
+	// or stream the large result sets item by item as NDJSON or a json array
+	stream := render.NDJsonStream(w, http.StatusOK)
+	for _, item := range items {
+		if err := stream.Encode(item); err != nil {
+			break
+		}
+	}
+	stream.Close()
+
 */
 package render
 
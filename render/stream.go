@@ -0,0 +1,121 @@
+package render
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	kContentNDJson = "application/x-ndjson"
+
+	kStreamFlushItems    = 100
+	kStreamFlushInterval = time.Second
+)
+
+// JsonStreamer encodes the items to the response one by one without keeping them in memory, the response would be
+// flushed periodically by the count of items or the interval.
+type JsonStreamer struct {
+	w       http.ResponseWriter
+	status  int
+	ndjson  bool
+	started bool
+	closed  bool
+	count   int
+
+	// Flush after this many items since the last flush, default is 100
+	FlushItems int
+	// Flush if this much time passed since the last flush, default is 1s
+	FlushInterval time.Duration
+
+	unflushed int
+	lastFlush time.Time
+}
+
+// JsonStream returns a new json streamer encoding the items as a json array, "application/json". The header and
+// status would be written with the first item.
+func JsonStream(w http.ResponseWriter, status int) *JsonStreamer {
+	return &JsonStreamer{
+		w:             w,
+		status:        status,
+		FlushItems:    kStreamFlushItems,
+		FlushInterval: kStreamFlushInterval,
+	}
+}
+
+// NDJsonStream returns a new json streamer encoding each item as a line, "application/x-ndjson".
+func NDJsonStream(w http.ResponseWriter, status int) *JsonStreamer {
+	s := JsonStream(w, status)
+	s.ndjson = true
+	return s
+}
+
+// Encode writes an item to the stream.
+func (s *JsonStreamer) Encode(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.start()
+	if s.ndjson {
+		data = append(data, '\n')
+	} else if s.count > 0 {
+		data = append([]byte{','}, data...)
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	s.count++
+	s.unflushed++
+	if s.unflushed >= s.FlushItems || time.Since(s.lastFlush) >= s.FlushInterval {
+		s.Flush()
+	}
+	return nil
+}
+
+// Count returns the count of the items written.
+func (s *JsonStreamer) Count() int {
+	return s.count
+}
+
+// Flush flushes the written items to the client.
+func (s *JsonStreamer) Flush() {
+	s.start()
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	s.unflushed = 0
+	s.lastFlush = time.Now()
+}
+
+// Close finishes the stream, e.g. closes the json array.
+func (s *JsonStreamer) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.start()
+	s.closed = true
+	if !s.ndjson {
+		if _, err := s.w.Write([]byte("]\n")); err != nil {
+			return err
+		}
+	}
+	s.Flush()
+	return nil
+}
+
+func (s *JsonStreamer) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.lastFlush = time.Now()
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", kContentNDJson+kContentCharset)
+		s.w.WriteHeader(s.status)
+		return
+	}
+	s.w.Header().Set("Content-Type", kContentJson+kContentCharset)
+	s.w.WriteHeader(s.status)
+	s.w.Write([]byte{'['})
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	kAPIKeyClaim = "api_key"
+)
+
+var (
+	// ErrNoAPIKey is returned by the KeyStore if the key id is not found.
+	ErrNoAPIKey = errors.New("api key not found")
+	// ErrAPIKeyExpired is returned if the api key is expired.
+	ErrAPIKeyExpired = errors.New("api key expired")
+)
+
+// APIKey is the stored api key, only the hash of the secret is kept.
+type APIKey struct {
+	Id       string    `json:"id"`
+	Owner    string    `json:"owner"`
+	Scopes   []string  `json:"scopes"`
+	Hash     string    `json:"hash"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// HasScope tells if the key is granted the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired tells if the key is expired, the zero Expires means never.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+func (k *APIKey) clone() *APIKey {
+	key := *k
+	key.Scopes = append([]string(nil), k.Scopes...)
+	return &key
+}
+
+// APIKeyFrom returns the api key the request is authenticated by.
+func APIKeyFrom(ctx context.Context) (*APIKey, bool) {
+	if principal, ok := PrincipalFrom(ctx); ok {
+		key, ok := principal.Claims[kAPIKeyClaim].(*APIKey)
+		return key, ok
+	}
+	return nil, false
+}
+
+// KeyStore keeps the api keys by the id.
+type KeyStore interface {
+	Get(id string) (*APIKey, error)
+	Put(key *APIKey) error
+	Delete(id string) error
+	// Touch records the last used time of the key
+	Touch(id string, lastUsed time.Time) error
+}
+
+// APIKeyOptions defines the options of the APIKeys.
+type APIKeyOptions struct {
+	// The prefix of the generated keys, e.g. "sk_live", default is "sk"
+	Prefix string
+	// The request header carrying the key, default is "X-API-Key"
+	Header string
+	// The query parameter carrying the key, empty means not allowed in the query
+	Query string
+	// The minimum interval to record the last used time, default is 1 minute
+	TouchInterval time.Duration
+}
+
+// APIKeys generates and verifies the api keys like "sk_1f2e3d4c5b6a7988_<secret>", the id part is used to look up the
+// key in the KeyStore and the secret is compared against the stored hash. It implements the Authenticator interface,
+// the principal has the owner as the subject and the scopes as the roles, so the AuthzPolicy can require the scopes.
+//
+//	keys := server.NewAPIKeys(store, server.APIKeyOptions{Prefix: "sk_live"})
+//	srv.Middleware(server.NewAuthWare(server.AuthOptions{Authenticators: []server.Authenticator{keys}}))
+//
+//	key, ok := server.APIKeyFrom(ctx)
+type APIKeys struct {
+	store KeyStore
+	opt   APIKeyOptions
+}
+
+// NewAPIKeys returns a new APIKeys with the store.
+func NewAPIKeys(store KeyStore, opt APIKeyOptions) *APIKeys {
+	if opt.Prefix == "" {
+		opt.Prefix = "sk"
+	}
+	if opt.Header == "" {
+		opt.Header = "X-API-Key"
+	}
+	if opt.TouchInterval <= 0 {
+		opt.TouchInterval = time.Minute
+	}
+	return &APIKeys{store, opt}
+}
+
+// Create generates a new key for the owner, the returned plain key is only available here. The zero expires means
+// the key never expires.
+func (k *APIKeys) Create(owner string, scopes []string, expires time.Time) (string, *APIKey, error) {
+	buf := make([]byte, 40)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	id, secret := hex.EncodeToString(buf[:8]), hex.EncodeToString(buf[8:])
+	key := &APIKey{
+		Id:      id,
+		Owner:   owner,
+		Scopes:  append([]string(nil), scopes...),
+		Hash:    hashAPIKeySecret(secret),
+		Created: time.Now(),
+		Expires: expires,
+	}
+	if err := k.store.Put(key); err != nil {
+		return "", nil, err
+	}
+	return k.opt.Prefix + "_" + id + "_" + secret, key.clone(), nil
+}
+
+// Revoke deletes the key by the id.
+func (k *APIKeys) Revoke(id string) error {
+	return k.store.Delete(id)
+}
+
+// Verify returns the stored key if the plain key is valid.
+func (k *APIKeys) Verify(plain string) (*APIKey, error) {
+	if !strings.HasPrefix(plain, k.opt.Prefix+"_") {
+		return nil, ErrInvalidCredentials
+	}
+	parts := strings.Split(plain[len(k.opt.Prefix)+1:], "_")
+	if len(parts) != 2 || len(parts[0]) != 16 {
+		return nil, ErrInvalidCredentials
+	}
+	key, err := k.store.Get(parts[0])
+	if err != nil {
+		if err != ErrNoAPIKey {
+			log.Errorf("Server api key lookup failed, %s", err)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(parts[1])), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, ErrAPIKeyExpired
+	}
+	if now.Sub(key.LastUsed) >= k.opt.TouchInterval {
+		key.LastUsed = now
+		if err := k.store.Touch(key.Id, now); err != nil {
+			log.Warnf("Server api key touch failed, %s", err)
+		}
+	}
+	return key, nil
+}
+
+// Authenticate implements the Authenticator interface.
+func (k *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	plain := r.Header.Get(k.opt.Header)
+	if plain == "" && k.opt.Query != "" {
+		plain = r.URL.Query().Get(k.opt.Query)
+	}
+	if plain == "" {
+		return nil, nil
+	}
+	key, err := k.Verify(plain)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		Subject: key.Owner,
+		Scheme:  "apikey",
+		Roles:   key.Scopes,
+		Claims:  map[string]interface{}{kAPIKeyClaim: key},
+	}, nil
+}
+
+// Challenge implements the Authenticator interface, there is no standard scheme for the api keys.
+func (k *APIKeys) Challenge() string {
+	return ""
+}
+
+func hashAPIKeySecret(secret string) string {
+	// the secrets are random enough, so a fast hash is fine
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// MemoryKeyStore is the in memory KeyStore.
+type MemoryKeyStore struct {
+	lock sync.RWMutex
+	keys map[string]*APIKey
+}
+
+// NewMemoryKeyStore returns a new in memory KeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]*APIKey)}
+}
+
+// Get implements the KeyStore interface.
+func (s *MemoryKeyStore) Get(id string) (*APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if key, ok := s.keys[id]; ok {
+		return key.clone(), nil
+	}
+	return nil, ErrNoAPIKey
+}
+
+// Put implements the KeyStore interface.
+func (s *MemoryKeyStore) Put(key *APIKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[key.Id] = key.clone()
+	return nil
+}
+
+// Delete implements the KeyStore interface.
+func (s *MemoryKeyStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.keys, id)
+	return nil
+}
+
+// Touch implements the KeyStore interface.
+func (s *MemoryKeyStore) Touch(id string, lastUsed time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if key, ok := s.keys[id]; ok {
+		key.LastUsed = lastUsed
+		return nil
+	}
+	return ErrNoAPIKey
+}
+
+// List returns the keys of the owner sorted by the created time, all the keys if the owner is empty.
+func (s *MemoryKeyStore) List(owner string) []*APIKey {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := []*APIKey{}
+	for _, key := range s.keys {
+		if owner == "" || key.Owner == owner {
+			keys = append(keys, key.clone())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+// FileKeyStore keeps the api keys in memory and persists them to a JSON file, the file is rewritten atomically on
+// every change.
+type FileKeyStore struct {
+	*MemoryKeyStore
+	lock sync.Mutex
+	file string
+}
+
+// NewFileKeyStore returns a new FileKeyStore, the existing keys are loaded from the file.
+func NewFileKeyStore(file string) (*FileKeyStore, error) {
+	s := &FileKeyStore{MemoryKeyStore: NewMemoryKeyStore(), file: file}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var keys []*APIKey
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			s.MemoryKeyStore.Put(key)
+		}
+	}
+	return s, nil
+}
+
+// Put implements the KeyStore interface.
+func (s *FileKeyStore) Put(key *APIKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.MemoryKeyStore.Put(key)
+	return s.save()
+}
+
+// Delete implements the KeyStore interface.
+func (s *FileKeyStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.MemoryKeyStore.Delete(id)
+	return s.save()
+}
+
+// Touch implements the KeyStore interface.
+func (s *FileKeyStore) Touch(id string, lastUsed time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.MemoryKeyStore.Touch(id, lastUsed); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// save writes all the keys to the file, should be called with the lock.
+func (s *FileKeyStore) save() error {
+	data, err := json.MarshalIndent(s.MemoryKeyStore.List(""), "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(s.file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), s.file); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
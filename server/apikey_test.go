@@ -0,0 +1,96 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestAPIKeys(t *testing.T) {
+	store := NewMemoryKeyStore()
+	keys := NewAPIKeys(store, APIKeyOptions{Prefix: "sk_test", Query: "api_key"})
+	plain, key, err := keys.Create("mijia", []string{"read"}, time.Time{})
+	if err != nil || !strings.HasPrefix(plain, "sk_test_"+key.Id+"_") {
+		t.Fatalf("Key should be created with the prefix and id, %q %v", plain, err)
+	}
+	if stored, _ := store.Get(key.Id); strings.Contains(plain, stored.Hash) || stored.Hash == "" {
+		t.Errorf("Only the hash of the secret should be stored")
+	}
+
+	srv := New(context.Background(), false)
+	srv.Middleware(NewAuthWare(AuthOptions{Authenticators: []Authenticator{keys}}))
+	srv.Get("/items", "Items", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		key, _ := APIKeyFrom(ctx)
+		if key.HasScope("read") {
+			w.Write([]byte(key.Owner))
+		}
+		return ctx
+	})
+	request := func(header, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/items?api_key="+query, nil)
+		r.Header.Set("X-API-Key", header)
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	if w := request(plain, ""); w.Code != 200 || w.Body.String() != "mijia" {
+		t.Errorf("Key in the header should be authenticated, %d %q", w.Code, w.Body.String())
+	}
+	if w := request("", plain); w.Code != 200 {
+		t.Errorf("Key in the query should be authenticated, %d", w.Code)
+	}
+	wrong := plain[:len(plain)-1] + "0"
+	if strings.HasSuffix(plain, "0") {
+		wrong = plain[:len(plain)-1] + "1"
+	}
+	if w := request(wrong, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong secret should be rejected, %d", w.Code)
+	}
+	if stored, _ := store.Get(key.Id); stored.LastUsed.IsZero() {
+		t.Errorf("Last used time should be recorded")
+	}
+
+	expired, _, _ := keys.Create("mijia", nil, time.Now().Add(-time.Second))
+	if _, err := keys.Verify(expired); err != ErrAPIKeyExpired {
+		t.Errorf("Expired key should be rejected, %v", err)
+	}
+	keys.Revoke(key.Id)
+	if w := request(plain, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked key should be rejected, %d", w.Code)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sweb-apikey")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "keys.json")
+	store, err := NewFileKeyStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, key, _ := NewAPIKeys(store, APIKeyOptions{}).Create("mijia", []string{"read", "write"}, time.Time{})
+
+	store, err = NewFileKeyStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewAPIKeys(store, APIKeyOptions{})
+	verified, err := keys.Verify(plain)
+	if err != nil || verified.Id != key.Id || !verified.HasScope("write") {
+		t.Fatalf("Key should be verified after reloading, %v %v", verified, err)
+	}
+	store, _ = NewFileKeyStore(file)
+	if stored, _ := store.Get(key.Id); stored.LastUsed.IsZero() {
+		t.Errorf("Last used time should be persisted")
+	}
+	if list := store.List("mijia"); len(list) != 1 {
+		t.Errorf("Keys of the owner should be listed, %v", list)
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kPrincipalKey = "inter_ctx_key_principal"
+)
+
+var (
+	// ErrInvalidCredentials is returned by the authenticators if the credentials are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// AuthError is returned by the authenticators to respond the status other than 401, e.g. 413 if the body to verify is
+// too large.
+type AuthError struct {
+	Status int
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *AuthError) Error() string {
+	return e.Err.Error()
+}
+
+// Principal is the authenticated client of the request.
+type Principal struct {
+	// The user id or the client name
+	Subject string
+	// The authentication scheme, e.g. "basic", "bearer" and "jwt"
+	Scheme string
+	// The roles for the authorization
+	Roles []string
+	// The extra claims, e.g. from the JWT
+	Claims map[string]interface{}
+}
+
+// String returns the scheme and subject, so that the principal can be used as the key, e.g. the rate limiting.
+func (p *Principal) String() string {
+	return p.Scheme + ":" + p.Subject
+}
+
+// HasRole tells if the principal has the role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a new context with the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, kPrincipalKey, principal)
+}
+
+// PrincipalFrom returns the authenticated principal of the request.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(kPrincipalKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Authenticator verifies the credentials of the request. It returns nil principal and nil error if the request
+// doesn't carry the credentials of its kind, and an error if the credentials are not valid, which is responded with
+// 401 unless it is an AuthError.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate header value for the 401 responses
+	Challenge() string
+}
+
+type basicAuth struct {
+	realm  string
+	verify func(username, password string) (*Principal, bool)
+}
+
+// BasicAuth returns the HTTP Basic authenticator verifying the username and password by the callback.
+func BasicAuth(realm string, verify func(username, password string) (*Principal, bool)) Authenticator {
+	return &basicAuth{realm, verify}
+}
+
+func (a *basicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	principal, ok := a.verify(username, password)
+	if !ok || principal == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if principal.Scheme == "" {
+		principal.Scheme = "basic"
+	}
+	return principal, nil
+}
+
+func (a *basicAuth) Challenge() string {
+	return `Basic realm="` + a.realm + `", charset="UTF-8"`
+}
+
+type bearerAuth struct {
+	lookup func(token string) (*Principal, bool)
+}
+
+// BearerAuth returns the authenticator for the opaque bearer tokens looked up by the func.
+func BearerAuth(lookup func(token string) (*Principal, bool)) Authenticator {
+	return &bearerAuth{lookup}
+}
+
+func (a *bearerAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	principal, ok := a.lookup(token)
+	if !ok || principal == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if principal.Scheme == "" {
+		principal.Scheme = "bearer"
+	}
+	return principal, nil
+}
+
+func (a *bearerAuth) Challenge() string {
+	return "Bearer"
+}
+
+// SecureCompare compares the secrets in constant time, can be used by the verify and lookup callbacks.
+func SecureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// AuthOptions defines the options of the AuthWare.
+type AuthOptions struct {
+	// The authenticators tried in order, e.g. the JWT should go before the opaque bearer tokens
+	Authenticators []Authenticator
+	// The requests without the credentials are allowed, the handlers can check the PrincipalFrom
+	Optional bool
+	// The route names which don't require the credentials
+	Public []string
+}
+
+// AuthWare is the authentication middleware, the principal would be put into the context for the handlers by the
+// first authenticator accepting the credentials. The invalid credentials would be rejected with 401 even for the
+// optional and public routes.
+//
+//	srv.Middleware(server.NewAuthWare(server.AuthOptions{
+//		Authenticators: []server.Authenticator{server.JWTAuth(verifier), server.BasicAuth("admin", checkPassword)},
+//		Public:         []string{"Login", "Health"},
+//	}))
+//
+//	principal, ok := server.PrincipalFrom(ctx)
+type AuthWare struct {
+	opt    AuthOptions
+	public map[string]bool
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *AuthWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	for _, auth := range m.opt.Authenticators {
+		principal, err := auth.Authenticate(r)
+		if authErr, ok := err.(*AuthError); ok && authErr.Status != http.StatusUnauthorized {
+			WriteProblem(w, authErr.Status, authErr.Error())
+			return ctx
+		}
+		if err != nil {
+			m.unauthorized(w, err.Error())
+			return ctx
+		}
+		if principal != nil {
+			return next(WithPrincipal(ctx, principal), w, r)
+		}
+	}
+	if m.opt.Optional || m.public[RouteName(ctx)] {
+		return next(ctx, w, r)
+	}
+	m.unauthorized(w, "Authentication is required")
+	return ctx
+}
+
+// NewAuthWare returns a new AuthWare.
+func NewAuthWare(opt AuthOptions) Middleware {
+	m := &AuthWare{
+		opt:    opt,
+		public: make(map[string]bool),
+	}
+	for _, name := range opt.Public {
+		m.public[name] = true
+	}
+	return m
+}
+
+func (m *AuthWare) unauthorized(w http.ResponseWriter, detail string) {
+	for _, auth := range m.opt.Authenticators {
+		if challenge := auth.Challenge(); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	WriteProblem(w, http.StatusUnauthorized, detail)
+}
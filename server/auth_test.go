@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthWare(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Middleware(NewAuthWare(AuthOptions{
+		Authenticators: []Authenticator{
+			BasicAuth("test", func(username, password string) (*Principal, bool) {
+				return &Principal{Subject: username}, username == "mijia" && SecureCompare(password, "secret")
+			}),
+			BearerAuth(func(token string) (*Principal, bool) {
+				return &Principal{Subject: "service"}, SecureCompare(token, "opaque")
+			}),
+		},
+		Public: []string{"Public"},
+	}))
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		if principal, ok := PrincipalFrom(ctx); ok {
+			w.Write([]byte(principal.String()))
+		}
+		return ctx
+	}
+	srv.Get("/private", "Private", handler)
+	srv.Get("/public", "Public", handler)
+
+	request := func(path string, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		if auth != nil {
+			auth(r)
+		}
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("/private", nil)
+	if w.Code != http.StatusUnauthorized || len(w.Header()["Www-Authenticate"]) != 2 {
+		t.Errorf("Missing credentials should get 401 with challenges, %d %v", w.Code, w.Header())
+	}
+	if w := request("/public", nil); w.Code != 200 || w.Body.String() != "" {
+		t.Errorf("Public route should be allowed anonymously, %d %q", w.Code, w.Body.String())
+	}
+	w = request("/private", func(r *http.Request) { r.SetBasicAuth("mijia", "secret") })
+	if w.Code != 200 || w.Body.String() != "basic:mijia" {
+		t.Errorf("Basic auth should be accepted, %d %q", w.Code, w.Body.String())
+	}
+	w = request("/public", func(r *http.Request) { r.SetBasicAuth("mijia", "wrong") })
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != kContentProblem {
+		t.Errorf("Invalid credentials should be rejected even for public routes, %d %v", w.Code, w.Header())
+	}
+	w = request("/private", func(r *http.Request) { r.Header.Set("Authorization", "Bearer opaque") })
+	if w.Code != 200 || w.Body.String() != "bearer:service" {
+		t.Errorf("Bearer token should be accepted, %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("secret")
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": "rsa", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC", "kid": "ec", "crv": "P-256",
+				"x": base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+				"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+			},
+			{"kty": "RSA", "kid": "enc", "use": "enc"},
+		},
+	})
+	file, _ := ioutil.TempFile("", "sweb-jwks")
+	file.Write(jwks)
+	file.Close()
+	defer os.Remove(file.Name())
+	keys, err := LoadJWKS(file.Name())
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("JWKS should be loaded with the signing keys, %v %v", keys, err)
+	}
+
+	verifier := NewJWTVerifier(JWTOptions{
+		Secret:   secret,
+		Keys:     keys,
+		Issuer:   "sweb",
+		Audience: "api",
+		Leeway:   time.Second,
+	})
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"sub": "mijia", "iss": "sweb", "aud": []string{"web", "api"}, "exp": now + 60, "roles": []string{"admin"},
+	}
+	for _, c := range []struct {
+		alg, kid string
+		key      interface{}
+	}{
+		{"HS256", "", secret},
+		{"RS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey},
+	} {
+		token := signTestJWT(t, c.alg, c.kid, c.key, claims)
+		got, err := verifier.Verify(token)
+		if err != nil {
+			t.Errorf("%s token should be verified, %v", c.alg, err)
+			continue
+		}
+		if principal := verifier.Principal(got); principal.Subject != "mijia" || !principal.HasRole("admin") {
+			t.Errorf("%s principal mismatched, %+v", c.alg, principal)
+		}
+	}
+
+	if _, err := verifier.Verify(signTestJWT(t, "RS256", "ec", rsaKey, claims)); err == nil {
+		t.Errorf("Algorithm should match the key type")
+	}
+	if _, err := verifier.Verify(signTestJWT(t, "HS256", "", []byte("other"), claims)); err == nil {
+		t.Errorf("Token with wrong signature should be rejected")
+	}
+	expired := map[string]interface{}{"sub": "mijia", "iss": "sweb", "aud": "api", "exp": now - 10}
+	if _, err := verifier.Verify(signTestJWT(t, "HS256", "", secret, expired)); err != ErrTokenExpired {
+		t.Errorf("Expired token should be rejected, %v", err)
+	}
+	noExpiry := map[string]interface{}{"sub": "mijia", "iss": "sweb", "aud": "api"}
+	if _, err := verifier.Verify(signTestJWT(t, "HS256", "", secret, noExpiry)); err != ErrInvalidToken {
+		t.Errorf("Token without expiration should be rejected, %v", err)
+	}
+	lenient := NewJWTVerifier(JWTOptions{Secret: secret, AllowNoExpiry: true})
+	if _, err := lenient.Verify(signTestJWT(t, "HS256", "", secret, noExpiry)); err != nil {
+		t.Errorf("Token without expiration should be verified if allowed, %v", err)
+	}
+	noExpiry["exp"] = "never"
+	if _, err := lenient.Verify(signTestJWT(t, "HS256", "", secret, noExpiry)); err == nil {
+		t.Errorf("Token with malformed expiration should be rejected")
+	}
+	wrongAud := map[string]interface{}{"sub": "mijia", "iss": "sweb", "aud": "other", "exp": now + 60}
+	if _, err := verifier.Verify(signTestJWT(t, "HS256", "", secret, wrongAud)); err != ErrInvalidToken {
+		t.Errorf("Token for other audience should be rejected, %v", err)
+	}
+
+	auth := JWTAuth(verifier)
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestJWT(t, "ES256", "ec", ecKey, claims))
+	if principal, err := auth.Authenticate(r); err != nil || principal.String() != "jwt:mijia" {
+		t.Errorf("JWT bearer should be authenticated, %v %v", principal, err)
+	}
+	r.Header.Set("Authorization", "Bearer opaque")
+	if principal, err := auth.Authenticate(r); principal != nil || err != nil {
+		t.Errorf("Opaque bearer should be left for the other authenticators")
+	}
+}
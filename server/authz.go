@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// AuthzPolicy defines the roles required by the route names. The names can be the glob patterns for the route
+// groups, e.g. "*_users" for the restful resource "users" or "Admin*". The exact names are matched first, then the
+// longest pattern; the versioned names like "Get_users@v2" fall back to the rules of "Get_users", and the exact rule
+// of "Get_users" wins over the patterns matching "Get_users@v2". The public names follow the same precedence.
+//
+//	{
+//		"routes": {
+//			"Delete_users": ["admin"],
+//			"*_users": ["user", "admin"],
+//			"Profile": []
+//		},
+//		"public": ["Login", "Health"],
+//		"deny_unlisted": true
+//	}
+type AuthzPolicy struct {
+	// The principal must have any of the roles, an empty list means any authenticated principal
+	Routes map[string][]string `json:"routes"`
+	// The routes which are open to everyone
+	Public []string `json:"public"`
+	// The routes without any rule are forbidden, otherwise they are left open
+	DenyUnlisted bool `json:"deny_unlisted"`
+}
+
+// NewAuthzPolicy returns an empty policy, the rules can be added by Require and Allow.
+func NewAuthzPolicy() *AuthzPolicy {
+	return &AuthzPolicy{Routes: make(map[string][]string)}
+}
+
+// LoadAuthzPolicy loads the policy from the JSON file.
+func LoadAuthzPolicy(file string) (*AuthzPolicy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := NewAuthzPolicy()
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+	for name := range policy.Routes {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Require adds the rule of the route name or pattern, the principal must have any of the roles.
+func (p *AuthzPolicy) Require(name string, roles ...string) *AuthzPolicy {
+	if p.Routes == nil {
+		p.Routes = make(map[string][]string)
+	}
+	p.Routes[name] = append(p.Routes[name], roles...)
+	return p
+}
+
+// Allow marks the route names or patterns as public.
+func (p *AuthzPolicy) Allow(names ...string) *AuthzPolicy {
+	p.Public = append(p.Public, names...)
+	return p
+}
+
+// Covers tells if the route is public or has a rule.
+func (p *AuthzPolicy) Covers(name string) bool {
+	_, ok := p.Roles(name)
+	return ok || p.IsPublic(name)
+}
+
+// IsPublic tells if the route is open to everyone, unless there is a more specific rule of the route.
+func (p *AuthzPolicy) IsPublic(name string) bool {
+	public, publicRank := authzMatch(name, p.Public)
+	if public == "" {
+		return false
+	}
+	rule, ruleRank := authzMatch(name, p.routeNames())
+	return rule == "" || publicRank < ruleRank || (publicRank == ruleRank && len(public) >= len(rule))
+}
+
+// Roles returns the roles required by the route, false if there is no rule for the route.
+func (p *AuthzPolicy) Roles(name string) ([]string, bool) {
+	if rule, _ := authzMatch(name, p.routeNames()); rule != "" {
+		return p.Routes[rule], true
+	}
+	return nil, false
+}
+
+func (p *AuthzPolicy) routeNames() []string {
+	names := make([]string, 0, len(p.Routes))
+	for name := range p.Routes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// authzMatch returns the most specific rule of the route and its rank, the lower is the more specific. The exact
+// names of all the candidates come before the patterns, so that a pattern matching the versioned name cannot
+// override the exact rule of the unversioned one, and the longest pattern wins among the patterns.
+func authzMatch(name string, rules []string) (string, int) {
+	candidates := authzCandidates(name)
+	for i, candidate := range candidates {
+		for _, rule := range rules {
+			if rule == candidate {
+				return rule, i
+			}
+		}
+	}
+	for i, candidate := range candidates {
+		best := ""
+		for _, pattern := range rules {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				if len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+					best = pattern
+				}
+			}
+		}
+		if best != "" {
+			return best, len(candidates) + i
+		}
+	}
+	return "", -1
+}
+
+func authzCandidates(name string) []string {
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		return []string{name, name[:i]}
+	}
+	return []string{name}
+}
+
+// AuthzWare enforces the AuthzPolicy with the principal from the AuthWare, which should be registered before it.
+// The requests without the principal get 401, and the ones without the required roles get 403.
+type AuthzWare struct {
+	policy *AuthzPolicy
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *AuthzWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	name := RouteName(ctx)
+	if name == "" || m.policy.IsPublic(name) {
+		return next(ctx, w, r)
+	}
+	roles, ok := m.policy.Roles(name)
+	if !ok && !m.policy.DenyUnlisted {
+		return next(ctx, w, r)
+	}
+	principal, authenticated := PrincipalFrom(ctx)
+	if !authenticated {
+		WriteProblem(w, http.StatusUnauthorized, "Authentication is required")
+		return ctx
+	}
+	if !ok {
+		WriteProblem(w, http.StatusForbidden, "No access policy for the route")
+		return ctx
+	}
+	if len(roles) == 0 {
+		return next(ctx, w, r)
+	}
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return next(ctx, w, r)
+		}
+	}
+	WriteProblem(w, http.StatusForbidden, "Insufficient role for the route")
+	return ctx
+}
+
+// NewAuthzWare returns a new AuthzWare.
+func NewAuthzWare(policy *AuthzPolicy) Middleware {
+	return &AuthzWare{policy}
+}
+
+// UnprotectedRoutes returns the sorted names of the routes which have no rule and are not public in the policy.
+func (s *Server) UnprotectedRoutes(policy *AuthzPolicy) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, route := range s.routes {
+		if !seen[route.name] && !policy.Covers(route.name) {
+			names = append(names, route.name)
+		}
+		seen[route.name] = true
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckAuthzPolicy is a test helper which fails the test for every route not covered by the policy, so that the new
+// routes cannot be added without deciding who can access them.
+func CheckAuthzPolicy(t interface {
+	Errorf(format string, args ...interface{})
+}, s *Server, policy *AuthzPolicy) {
+	for _, name := range s.UnprotectedRoutes(policy) {
+		t.Errorf("The route %q has no authorization policy", name)
+	}
+}
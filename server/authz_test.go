@@ -0,0 +1,135 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestAuthzWare(t *testing.T) {
+	file, _ := ioutil.TempFile("", "sweb-authz")
+	file.WriteString(`{
+		"routes": {"Delete_users": ["admin"], "*_users": ["user", "admin"], "Profile": []},
+		"public": ["Health"],
+		"deny_unlisted": true
+	}`)
+	file.Close()
+	defer os.Remove(file.Name())
+	policy, err := LoadAuthzPolicy(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(context.Background(), false)
+	srv.Middleware(NewAuthWare(AuthOptions{
+		Authenticators: []Authenticator{BearerAuth(func(token string) (*Principal, bool) {
+			return &Principal{Subject: token, Roles: []string{token}}, token == "user" || token == "admin"
+		})},
+		Optional: true,
+	}))
+	srv.Middleware(NewAuthzWare(policy))
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("ok"))
+		return ctx
+	}
+	srv.Delete("/users", "Delete_users", ok)
+	srv.Get("/profile", "Profile", ok)
+	srv.Get("/health", "Health", ok)
+	srv.Get("/debug", "Debug", ok)
+	srv.Version(2).Get("/users", "Get_users", ok)
+
+	for _, c := range []struct {
+		method, path, token string
+		code                int
+	}{
+		{"GET", "/health", "", 200},
+		{"GET", "/users", "", http.StatusUnauthorized},
+		{"GET", "/users", "user", 200},
+		{"GET", "/v2/users", "user", 200},
+		{"DELETE", "/users", "user", http.StatusForbidden},
+		{"DELETE", "/users", "admin", 200},
+		{"GET", "/profile", "user", 200},
+		{"GET", "/debug", "admin", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		srv.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s %s with %q should get %d, got %d", c.method, c.path, c.token, c.code, w.Code)
+		}
+	}
+
+	if names := srv.UnprotectedRoutes(policy); !reflect.DeepEqual(names, []string{"Debug"}) {
+		t.Errorf("Unprotected routes mismatched, %v", names)
+	}
+	policy.Require("Debug", "admin")
+	CheckAuthzPolicy(t, srv, policy)
+}
+
+func TestAuthzPolicyPrecedence(t *testing.T) {
+	policy := NewAuthzPolicy().Require("Get_*").Require("Get_admin", "admin").Require("Get_report@v2", "admin")
+	policy.Allow("Get_report*", "Get_report")
+	if roles, _ := policy.Roles("Get_admin@v2"); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("Exact rule of the unversioned route should win over the pattern, got %v", roles)
+	}
+	if roles, _ := policy.Roles("Get_users@v2"); len(roles) != 0 {
+		t.Errorf("Pattern should match the versioned route, got %v", roles)
+	}
+	if policy.IsPublic("Get_report@v2") {
+		t.Errorf("Public pattern should not override the exact rule")
+	}
+	if !policy.IsPublic("Get_report") {
+		t.Errorf("Public pattern should win over the shorter pattern")
+	}
+
+	srv := New(context.Background(), false)
+	srv.Middleware(NewAuthWare(AuthOptions{
+		Authenticators: []Authenticator{BearerAuth(func(token string) (*Principal, bool) {
+			return &Principal{Subject: token, Roles: []string{token}}, true
+		})},
+		Optional: true,
+	}))
+	srv.Middleware(NewAuthzWare(policy))
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("ok"))
+		return ctx
+	}
+	srv.Version(2).Get("/admin", "Get_admin", ok)
+	srv.Version(1).Get("/report", "Get_report", ok)
+	srv.Version(2).Get("/report", "Get_report", ok)
+
+	for _, c := range []struct {
+		path, token, version string
+		code                 int
+	}{
+		{"/v2/admin", "user", "", http.StatusForbidden},
+		{"/admin", "user", "2", http.StatusForbidden},
+		{"/v1/report", "", "", 200},
+		{"/report", "", "1", 200},
+		{"/v2/report", "", "", http.StatusUnauthorized},
+		{"/report", "", "2", http.StatusUnauthorized},
+		{"/report", "", "", http.StatusUnauthorized},
+		{"/report", "admin", "2", 200},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.version != "" {
+			r.Header.Set(kVersionHeader, c.version)
+		}
+		srv.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s of version %q with %q should get %d, got %d", c.path, c.version, c.token, c.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	kBatchMaxRequests = 50
+	kBatchTimeout     = 10 * time.Second
+)
+
+// BatchOptions defines the options for the batch endpoint.
+type BatchOptions struct {
+	// Max count of the sub requests in a batch, default is 50
+	MaxRequests int
+
+	// Dispatch the sub requests in parallel with the limit, the sub requests would be dispatched in sequence if
+	// the value is less than 2.
+	Parallel int
+
+	// The headers of the batch request which the sub requests would inherit, e.g. "Authorization" and "Cookie".
+	InheritHeaders []string
+
+	// The deadline of each sub request, default is 10 seconds. The sub request would be notified to stop by the
+	// CloseNotify and responded with 504 after the deadline.
+	Timeout time.Duration
+}
+
+// BatchRequest defines a sub request in the batch.
+type BatchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResponse defines the response of a sub request, the json body would be embedded as is,
+// other bodies would be responded as a string.
+type BatchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// EnableBatch registers the batch endpoint at the path with the given routing name, which accepts a json array of
+// the sub requests and dispatches each of them through the server's router and middlewares. The sub requests cannot
+// be the batch requests or the streaming responses like SSE, which would be rejected with 400.
+func (s *Server) EnableBatch(path string, name string, opt BatchOptions) {
+	if opt.MaxRequests <= 0 {
+		opt.MaxRequests = kBatchMaxRequests
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = kBatchTimeout
+	}
+	s.Post(path, name, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		data, err := readLimitedBody(r)
+		if err == ErrBodyTooLarge {
+			WriteProblem(w, http.StatusRequestEntityTooLarge, "The batch requests exceed the size limit")
+			return ctx
+		}
+		var reqs []BatchRequest
+		if err == nil {
+			err = json.Unmarshal(data, &reqs)
+		}
+		if err != nil {
+			WriteProblem(w, http.StatusBadRequest, "Cannot decode the batch requests, "+err.Error())
+			return ctx
+		}
+		if len(reqs) > opt.MaxRequests {
+			WriteProblem(w, http.StatusBadRequest, fmt.Sprintf("Too many requests in the batch, the limit is %d", opt.MaxRequests))
+			return ctx
+		}
+
+		// the parent CloseNotify only fires once, so it is fanned out to the sub requests by closing the channel
+		parentClosed, finished := make(chan struct{}), make(chan struct{})
+		defer close(finished)
+		if notifier, ok := w.(http.CloseNotifier); ok {
+			closeNotify := notifier.CloseNotify()
+			go func() {
+				select {
+				case <-closeNotify:
+					close(parentClosed)
+				case <-finished:
+				}
+			}()
+		}
+
+		responses := make([]*BatchResponse, len(reqs))
+		if opt.Parallel < 2 {
+			for i, req := range reqs {
+				responses[i] = s.dispatchBatch(r, parentClosed, req, opt)
+			}
+		} else {
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, opt.Parallel)
+			for i, req := range reqs {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int, req BatchRequest) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					responses[i] = s.dispatchBatch(r, parentClosed, req, opt)
+				}(i, req)
+			}
+			wg.Wait()
+		}
+
+		data, err = json.Marshal(responses)
+		if err != nil {
+			WriteProblem(w, http.StatusInternalServerError, err.Error())
+			return ctx
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(data, '\n'))
+		return ctx
+	})
+	s.routes[len(s.routes)-1].batch = true
+}
+
+func (s *Server) dispatchBatch(parent *http.Request, parentClosed <-chan struct{}, req BatchRequest,
+	opt BatchOptions) *BatchResponse {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = "GET"
+	}
+	if req.Path == "" || req.Path[0] != '/' {
+		return batchError(http.StatusBadRequest, "The path of the sub request should start with '/'")
+	}
+	var body []byte
+	if len(req.Body) > 0 {
+		// a json string body would be sent as the raw string, e.g. the form encoded body
+		var str string
+		if err := json.Unmarshal(req.Body, &str); err == nil {
+			body = []byte(str)
+		} else {
+			body = req.Body
+		}
+	}
+	r, err := http.NewRequest(method, req.Path, bytes.NewReader(body))
+	if err != nil {
+		return batchError(http.StatusBadRequest, err.Error())
+	}
+	for _, key := range opt.InheritHeaders {
+		if value := parent.Header.Get(key); value != "" {
+			r.Header.Set(key, value)
+		}
+	}
+	if len(body) > 0 && json.Valid(body) {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range req.Headers {
+		r.Header.Set(key, value)
+	}
+	r.Host = parent.Host
+	r.RemoteAddr = parent.RemoteAddr
+	r.TLS = parent.TLS
+
+	w := newBufferedResponseWriter()
+	done := make(chan struct{})
+	panicked := false
+	go func() {
+		defer close(done)
+		// the panic of a sub request would not crash the server without the RecoveryWare
+		defer func() {
+			if err := recover(); err != nil {
+				stack := make([]byte, 1024*8)
+				stack = stack[:runtime.Stack(stack, false)]
+				log.Errorf("[Batch] Sub request %s %s PANIC: %s\n%s", method, req.Path, err, stack)
+				panicked = true
+			}
+		}()
+		s.ServeHTTP(w, r)
+	}()
+	timer := time.NewTimer(opt.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-parentClosed:
+		w.notifyClosed()
+		return batchError(http.StatusServiceUnavailable, "The batch request is closed by the client")
+	case <-timer.C:
+		// the handler may still be running, so the buffered response would not be touched any more
+		w.notifyClosed()
+		return batchError(http.StatusGatewayTimeout, fmt.Sprintf("The sub request timed out after %s", opt.Timeout))
+	}
+	if panicked {
+		return batchError(http.StatusInternalServerError, "The sub request failed")
+	}
+	if w.streaming {
+		return batchError(http.StatusBadRequest, "The streaming response cannot be batched")
+	}
+	return w.batchResponse()
+}
+
+// isBatchSubRequest tells if the request is dispatched by the batch endpoint.
+func isBatchSubRequest(w http.ResponseWriter) bool {
+	_, ok := w.(*bufferedResponseWriter)
+	return ok
+}
+
+func batchError(status int, detail string) *BatchResponse {
+	data, _ := json.Marshal(NewProblem(status, detail))
+	return &BatchResponse{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": kContentProblem},
+		Body:    data,
+	}
+}
+
+// bufferedResponseWriter is an http.ResponseWriter keeping the whole response in memory, the flushing handlers are
+// streaming and would be notified to stop by the CloseNotify.
+type bufferedResponseWriter struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	streaming bool
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header: make(http.Header),
+		closed: make(chan bool),
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.notifyClosed()
+	}
+}
+
+func (w *bufferedResponseWriter) CloseNotify() <-chan bool {
+	return w.closed
+}
+
+func (w *bufferedResponseWriter) notifyClosed() {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+	})
+}
+
+func (w *bufferedResponseWriter) batchResponse() *BatchResponse {
+	res := &BatchResponse{
+		Status:  w.status,
+		Headers: make(map[string]string),
+	}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	for key := range w.header {
+		res.Headers[key] = w.header.Get(key)
+	}
+	if w.body.Len() == 0 {
+		return res
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.header.Get("Content-Type"))
+	if isJsonMediaType(mediaType) && json.Valid(w.body.Bytes()) {
+		var compact bytes.Buffer
+		json.Compact(&compact, w.body.Bytes())
+		res.Body = compact.Bytes()
+	} else {
+		res.Body, _ = json.Marshal(w.body.String())
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBatch(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Get("/hello/:name", "Hello", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		fmt.Fprintf(w, "Hello, %s, %s", Params(ctx, "name"), r.Header.Get("Authorization"))
+		return ctx
+	})
+	srv.Post("/echo", "Echo", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		var v interface{}
+		json.NewDecoder(r.Body).Decode(&v)
+		data, _ := json.Marshal(v)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+		return ctx
+	})
+
+	for _, parallel := range []int{0, 2} {
+		path := fmt.Sprintf("/batch%d", parallel)
+		srv.EnableBatch(path, fmt.Sprintf("Batch%d", parallel), BatchOptions{
+			Parallel:       parallel,
+			MaxRequests:    3,
+			InheritHeaders: []string{"Authorization"},
+		})
+
+		body := `[
+			{"method": "GET", "path": "/hello/mijia"},
+			{"method": "POST", "path": "/echo", "body": {"a": 1}},
+			{"method": "GET", "path": "/nowhere"}
+		]`
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Authorization", "token")
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong batch status, expected=200, got=%d", w.Code)
+		}
+		var responses []BatchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 3 {
+			t.Fatalf("Wrong batch responses, %s", w.Body)
+		}
+		if responses[0].Status != http.StatusOK || string(responses[0].Body) != `"Hello, mijia, token"` {
+			t.Errorf("Wrong response for the hello, %+v", responses[0])
+		}
+		if responses[1].Status != http.StatusCreated || string(responses[1].Body) != `{"a":1}` {
+			t.Errorf("Wrong response for the echo, %+v", responses[1])
+		}
+		if responses[2].Status != http.StatusNotFound {
+			t.Errorf("Wrong response for the not found, %+v", responses[2])
+		}
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", path, strings.NewReader(`[{}, {}, {}, {}]`))
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Should reject the batch with too many requests, got=%d", w.Code)
+		}
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Get("/events", "Events", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		stream, err := SSE(ctx, w)
+		if err != nil {
+			return ctx
+		}
+		for {
+			select {
+			case <-stream.Done():
+				return ctx
+			case <-time.After(10 * time.Millisecond):
+				stream.Send("tick", "", "")
+			}
+		}
+	})
+	srv.Get("/slow", "Slow", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		time.Sleep(200 * time.Millisecond)
+		return ctx
+	})
+	srv.Get("/panic", "Panic", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		panic("boom")
+	})
+	srv.EnableBatch("/batch", "Batch", BatchOptions{Timeout: 50 * time.Millisecond})
+	srv.EnableBatch("/other", "OtherBatch", BatchOptions{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/batch", strings.NewReader(`[
+		{"path": "/events"},
+		{"path": "/slow"},
+		{"method": "POST", "path": "/other", "body": []},
+		{"method": "POST", "path": "/batch", "body": []},
+		{"path": "/panic"}
+	]`))
+	start := time.Now()
+	srv.ServeHTTP(w, r)
+	var responses []BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 5 {
+		t.Fatalf("Wrong batch responses, %s", w.Body)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Batch should not be blocked by the sub requests")
+	}
+	for i, status := range []int{http.StatusBadRequest, http.StatusGatewayTimeout, http.StatusBadRequest,
+		http.StatusBadRequest, http.StatusInternalServerError} {
+		if responses[i].Status != status {
+			t.Errorf("Sub request %d should get %d, got %+v", i, status, responses[i])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/batch", strings.NewReader("["+strings.Repeat(" ", kBodyMaxSize)+"]"))
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized batch should be rejected with 413, got %d", w.Code)
+	}
+}
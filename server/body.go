@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kBodyMaxSize             = 10 << 20
+	kBodyMaxDecompressedSize = 50 << 20
+)
+
+// ErrBodyTooLarge is returned by reading the request body which exceeds the limits of the BodyLimitWare.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// BodyLimits defines the maximum sizes of the request body, zero means no limit.
+type BodyLimits struct {
+	// The max size of the body on the wire, i.e. compressed if Content-Encoding is set.
+	MaxSize int64
+	// The max size of the body after decompression, to defeat the zip bombs.
+	MaxDecompressedSize int64
+}
+
+// DefaultBodyLimits returns the default limits, 10MB on the wire and 50MB decompressed.
+func DefaultBodyLimits() BodyLimits {
+	return BodyLimits{
+		MaxSize:             kBodyMaxSize,
+		MaxDecompressedSize: kBodyMaxDecompressedSize,
+	}
+}
+
+// BodyLimitWare is the middleware which decompresses the gzip/deflate request bodies by the Content-Encoding and
+// enforces the maximum body sizes. The response would be replaced by a 413 problem if the handler reads beyond
+// the limits, e.g. by form.ParamBodyJson or r.FormValue.
+type BodyLimitWare struct {
+	limits BodyLimits
+	routes map[string]BodyLimits
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *BodyLimitWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	if r.Body == nil || r.ContentLength == 0 {
+		return next(ctx, w, r)
+	}
+	limits := m.limits
+	if routeLimits, ok := m.routes[RouteName(ctx)]; ok {
+		limits = routeLimits
+	}
+	if limits.MaxSize > 0 && r.ContentLength > limits.MaxSize {
+		WriteProblem(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body exceeds the limit of %d bytes", limits.MaxSize))
+		return ctx
+	}
+
+	exceeded := new(bool)
+	body := &limitedBody{ReadCloser: r.Body, limit: limits.MaxSize, exceeded: exceeded}
+	r.Body = body
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip", "deflate":
+		r.Body = &limitedBody{
+			ReadCloser: &decompressBody{body: body, encoding: encoding},
+			limit:      limits.MaxDecompressedSize,
+			exceeded:   exceeded,
+		}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	default:
+		WriteProblem(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Encoding %q", encoding))
+		return ctx
+	}
+
+	lw := &limitedBodyWriter{ResponseWriter: w.(ResponseWriter), body: r.Body.(*limitedBody)}
+	ctx = next(ctx, lw, r)
+	if !lw.Written() && lw.body.isExceeded() {
+		lw.WriteHeader(http.StatusOK)
+	}
+	return ctx
+}
+
+// NewBodyLimitWare returns a new BodyLimitWare, the routes map defines the limits for the route names which
+// overrides the default limits.
+func NewBodyLimitWare(limits BodyLimits, routes map[string]BodyLimits) Middleware {
+	return &BodyLimitWare{
+		limits: limits,
+		routes: routes,
+	}
+}
+
+// readLimitedBody reads the request body within the limits of the BodyLimitWare if registered before, otherwise the
+// default limit of the body size, ErrBodyTooLarge is returned if the body exceeds.
+func readLimitedBody(r *http.Request) ([]byte, error) {
+	if _, ok := r.Body.(*limitedBody); ok {
+		return ioutil.ReadAll(r.Body)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, kBodyMaxSize+1))
+	if err == nil && len(data) > kBodyMaxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return data, err
+}
+
+// limitedBody returns ErrBodyTooLarge when reading more than the limit, the exceeded flag is shared by the
+// compressed and decompressed readers.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded *bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if *b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read+1 {
+		p = p[:b.limit-b.read+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		*b.exceeded = true
+		return n - int(b.read-b.limit), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) isExceeded() bool {
+	return *b.exceeded
+}
+
+// decompressBody creates the decompressor lazily at the first read.
+type decompressBody struct {
+	body     *limitedBody
+	encoding string
+	reader   io.ReadCloser
+}
+
+func (b *decompressBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		var err error
+		if b.encoding == "deflate" {
+			b.reader, err = zlib.NewReader(b.body)
+		} else {
+			b.reader, err = gzip.NewReader(b.body)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decompressBody) Close() error {
+	if b.reader != nil {
+		b.reader.Close()
+	}
+	return b.body.Close()
+}
+
+// limitedBodyWriter replaces the response by a 413 problem if the body limit is exceeded before the response is
+// written, the writes from the handler would be discarded.
+type limitedBodyWriter struct {
+	ResponseWriter
+	body    *limitedBody
+	discard bool
+}
+
+func (lw *limitedBodyWriter) WriteHeader(s int) {
+	if lw.Written() {
+		return
+	}
+	if lw.body.isExceeded() {
+		lw.discard = true
+		for key := range lw.Header() {
+			lw.Header().Del(key)
+		}
+		NewProblem(http.StatusRequestEntityTooLarge, "Request body exceeds the limit").Write(lw.ResponseWriter)
+		return
+	}
+	lw.ResponseWriter.WriteHeader(s)
+}
+
+func (lw *limitedBodyWriter) Write(b []byte) (int, error) {
+	if !lw.Written() {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.discard {
+		return len(b), nil
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+func (lw *limitedBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+	return hijacker.Hijack()
+}
+
+func (lw *limitedBodyWriter) CloseNotify() <-chan bool {
+	if notifier, ok := lw.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mijia/sweb/form"
+	"golang.org/x/net/context"
+)
+
+func gzipData(data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestBodyLimitWare(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Middleware(NewBodyLimitWare(BodyLimits{MaxSize: 64, MaxDecompressedSize: 128}, map[string]BodyLimits{
+		"Upload": {MaxSize: 1024},
+	}))
+	echo := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		var v map[string]string
+		if err := form.ParamBodyJson(r, &v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return ctx
+		}
+		w.Write([]byte(v["name"]))
+		return ctx
+	}
+	srv.Post("/echo", "Echo", echo)
+	srv.Post("/upload", "Upload", echo)
+	srv.Post("/form", "Form", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("name=" + r.FormValue("name")))
+		return ctx
+	})
+
+	request := func(path string, body []byte, encoding string, contentLength bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+		if !contentLength {
+			r.ContentLength = -1
+		}
+		if path == "/form" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if encoding != "" {
+			r.Header.Set("Content-Encoding", encoding)
+		}
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("/echo", []byte(`{"name":"sweb"}`), "", true); w.Code != 200 || w.Body.String() != "sweb" {
+		t.Errorf("Small body should be accepted, %d %q", w.Code, w.Body.String())
+	}
+	if w := request("/echo", gzipData(`{"name":"gzip"}`), "gzip", true); w.Code != 200 || w.Body.String() != "gzip" {
+		t.Errorf("Gzip body should be decompressed, %d %q", w.Code, w.Body.String())
+	}
+
+	large := []byte(`{"name":"` + strings.Repeat("x", 100) + `"}`)
+	if w := request("/echo", large, "", true); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Large Content-Length should be rejected with 413, got %d", w.Code)
+	}
+	w := request("/echo", large, "", false)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("Content-Type") != kContentProblem {
+		t.Errorf("Large chunked body should be replaced by a 413 problem, got %d %v", w.Code, w.Header())
+	}
+	if w := request("/upload", large, "", false); w.Code != 200 {
+		t.Errorf("Route limits should override the default, got %d", w.Code)
+	}
+
+	bomb := gzipData(`{"name":"` + strings.Repeat("x", 10000) + `"}`)
+	if len(bomb) > 64 {
+		t.Fatalf("The bomb should be small when compressed, %d", len(bomb))
+	}
+	if w := request("/echo", bomb, "gzip", true); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Decompressed size should be limited, got %d", w.Code)
+	}
+	if w := request("/form", []byte("name="+strings.Repeat("y", 100)), "", false); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("FormValue reading beyond the limit should get 413, got %d", w.Code)
+	}
+	if w := request("/echo", []byte("data"), "br", true); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Unknown Content-Encoding should be rejected with 415, got %d", w.Code)
+	}
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bufio"
+	"container/list"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kCacheTagsKey = "inter_ctx_key_cache_tags"
+
+	kCacheMaxEntries  = 1000
+	kCacheMaxBodySize = 1 << 20
+)
+
+// CacheOptions defines the options of the CacheWare.
+type CacheOptions struct {
+	// The max count of the cached responses, default is 1000
+	MaxEntries int
+	// The responses with larger bodies would not be cached, default is 1MB
+	MaxBodySize int
+	// The default ttl of the public responses without the max-age in Cache-Control, zero means not cached by default
+	TTL time.Duration
+	// The ttls by the route names overriding the default one, the responses of the routes are cached without the
+	// "public" in Cache-Control, negative means never cached for the route
+	Routes map[string]time.Duration
+	// The requests with any of the cookies are passed to the handlers, e.g. the session and auth cookies
+	PrivateCookies []string
+	// The requests are passed to the handlers if it returns true, e.g. for the users signed in
+	Bypass func(ctx context.Context, r *http.Request) bool
+}
+
+// CacheWare is the middleware caching the complete GET responses in a bounded LRU, keyed by the url and the
+// headers listed in the Vary. The responses marked as "public" in the Cache-Control or of the routes with the ttls
+// are cached, and the max-age/s-maxage defines the ttl, the no-store, no-cache and private ones are not cached. The
+// requests with the credentials, the principal, the private cookies or the Bypass are always passed to the handlers,
+// and the responses with the per request CSP nonce of the SecurityWare are not cached. The concurrent misses for the
+// same key would be coalesced so that the handler runs only once. HEAD requests can be served from the cached GET
+// responses.
+//
+//	cache := server.NewCacheWare(server.CacheOptions{
+//		Routes:         map[string]time.Duration{"UserPage": 5 * time.Minute},
+//		Bypass: func(ctx context.Context, r *http.Request) bool {
+//			return session.HasSession(ctx)
+//		},
+//	})
+//
+// The handler can tag the response for invalidation later:
+//
+//	func (h *Handler) UserPage(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+//		h.RenderHtml(w, "user.html", data)
+//		return server.CacheTags(ctx, "users", "user:"+id)
+//	}
+//
+//	cache.Invalidate("user:" + id)
+type CacheWare struct {
+	opt     CacheOptions
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+	flights map[string]chan struct{}
+}
+
+type cacheEntry struct {
+	key     string
+	vary    []string
+	status  int
+	header  http.Header
+	body    []byte
+	tags    []string
+	created time.Time
+	expires time.Time
+}
+
+// CacheTags tags the cached response of the request for the invalidation, returns the new context which should be
+// returned by the handler.
+func CacheTags(ctx context.Context, tags ...string) context.Context {
+	if prev, ok := ctx.Value(kCacheTagsKey).([]string); ok {
+		tags = append(append([]string{}, prev...), tags...)
+	}
+	return context.WithValue(ctx, kCacheTagsKey, tags)
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *CacheWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	if (r.Method != "GET" && r.Method != "HEAD") || !m.cacheableRequest(ctx, r) {
+		return next(ctx, w, r)
+	}
+	reqCacheControl := parseCacheControl(r.Header.Get("Cache-Control"))
+	if _, ok := reqCacheControl["no-store"]; ok {
+		return next(ctx, w, r)
+	}
+	_, noCache := reqCacheControl["no-cache"]
+	baseKey := r.URL.RequestURI()
+
+	m.lock.Lock()
+	key := m.variantKey(baseKey, r)
+	if !noCache {
+		if entry := m.get(key); entry != nil {
+			m.lock.Unlock()
+			m.writeEntry(w, r, entry)
+			return ctx
+		}
+	}
+	if r.Method == "HEAD" {
+		m.lock.Unlock()
+		return next(ctx, w, r)
+	}
+	if flight, ok := m.flights[key]; ok {
+		// wait for the first miss, and run the handler if the response is not cacheable
+		m.lock.Unlock()
+		select {
+		case <-flight:
+		case <-ctx.Done():
+			return ctx
+		}
+		m.lock.Lock()
+		entry := m.get(m.variantKey(baseKey, r))
+		m.lock.Unlock()
+		if entry == nil {
+			return next(ctx, w, r)
+		}
+		m.writeEntry(w, r, entry)
+		return ctx
+	}
+	flight := make(chan struct{})
+	m.flights[key] = flight
+	m.lock.Unlock()
+	defer func() {
+		m.lock.Lock()
+		delete(m.flights, key)
+		m.lock.Unlock()
+		close(flight)
+	}()
+
+	cw := &cacheResponseWriter{ResponseWriter: w.(ResponseWriter), maxSize: m.opt.MaxBodySize}
+	cw.Header().Set("X-Cache", "MISS")
+	newCtx := next(ctx, cw, r)
+	if ttl, ok := m.responseTTL(ctx, cw); ok {
+		tags, _ := newCtx.Value(kCacheTagsKey).([]string)
+		m.store(baseKey, r, cw, ttl, tags)
+	}
+	return newCtx
+}
+
+// NewCacheWare returns a new CacheWare which should be kept to invalidate the cached responses.
+func NewCacheWare(opt CacheOptions) *CacheWare {
+	if opt.MaxEntries <= 0 {
+		opt.MaxEntries = kCacheMaxEntries
+	}
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = kCacheMaxBodySize
+	}
+	return &CacheWare{
+		opt:     opt,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]struct{}),
+		flights: make(map[string]chan struct{}),
+	}
+}
+
+// Invalidate removes the cached responses with any of the tags, returns the count of removed responses.
+func (m *CacheWare) Invalidate(tags ...string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	count := 0
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			if elem, ok := m.entries[key]; ok {
+				m.remove(elem)
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Purge removes all the cached responses.
+func (m *CacheWare) Purge() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lru.Init()
+	m.entries = make(map[string]*list.Element)
+	m.tags = make(map[string]map[string]struct{})
+}
+
+// Len returns the count of the cached responses.
+func (m *CacheWare) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lru.Len()
+}
+
+func (m *CacheWare) cacheableRequest(ctx context.Context, r *http.Request) bool {
+	if ttl, ok := m.opt.Routes[RouteName(ctx)]; ok && ttl < 0 {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	for _, name := range m.opt.PrivateCookies {
+		if _, err := r.Cookie(name); err == nil {
+			return false
+		}
+	}
+	if _, ok := PrincipalFrom(ctx); ok {
+		return false
+	}
+	return m.opt.Bypass == nil || !m.opt.Bypass(ctx, r)
+}
+
+// variantKey returns the key by the Vary headers of the cached response, should be called with the lock.
+func (m *CacheWare) variantKey(baseKey string, r *http.Request) string {
+	elem, ok := m.entries[baseKey]
+	if !ok {
+		return baseKey
+	}
+	vary := elem.Value.(*cacheEntry).vary
+	if len(vary) == 0 {
+		return baseKey
+	}
+	return variantKey(baseKey, vary, r)
+}
+
+func variantKey(baseKey string, vary []string, r *http.Request) string {
+	parts := []string{baseKey}
+	for _, name := range vary {
+		parts = append(parts, name+"="+strings.Join(r.Header[http.CanonicalHeaderKey(name)], ","))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// get returns the valid cached response, should be called with the lock.
+func (m *CacheWare) get(key string) *cacheEntry {
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		m.remove(elem)
+		return nil
+	}
+	if entry.status == 0 {
+		// the vary marker
+		return nil
+	}
+	m.lru.MoveToFront(elem)
+	return entry
+}
+
+func (m *CacheWare) responseTTL(ctx context.Context, cw *cacheResponseWriter) (time.Duration, bool) {
+	if cw.uncacheable {
+		return 0, false
+	}
+	switch cw.Status() {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return 0, false
+	}
+	header := cw.Header()
+	if header.Get("Set-Cookie") != "" || header.Get("Vary") == "*" {
+		return 0, false
+	}
+	for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+		if strings.Contains(header.Get(name), "'nonce-") {
+			return 0, false
+		}
+	}
+	cacheControl := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cacheControl[directive]; ok {
+			return 0, false
+		}
+	}
+	routeTTL, routed := m.opt.Routes[RouteName(ctx)]
+	if _, public := cacheControl["public"]; !public && !routed {
+		return 0, false
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := cacheControl[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	ttl := m.opt.TTL
+	if routed {
+		ttl = routeTTL
+	}
+	return ttl, ttl > 0
+}
+
+func (m *CacheWare) store(baseKey string, r *http.Request, cw *cacheResponseWriter, ttl time.Duration, tags []string) {
+	var vary []string
+	for _, value := range cw.Header()["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	header := make(http.Header)
+	for key, values := range cw.Header() {
+		header[key] = append([]string{}, values...)
+	}
+	header.Del("X-Cache")
+	now := time.Now()
+	entry := &cacheEntry{
+		key:     baseKey,
+		vary:    vary,
+		status:  cw.Status(),
+		header:  header,
+		body:    cw.body,
+		tags:    tags,
+		created: now,
+		expires: now.Add(ttl),
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(vary) > 0 {
+		m.add(&cacheEntry{key: baseKey, vary: vary, tags: tags, expires: entry.expires})
+		entry.key = variantKey(baseKey, vary, r)
+	}
+	m.add(entry)
+}
+
+// add adds the entry to the front of lru and evicts the oldest ones, should be called with the lock.
+func (m *CacheWare) add(entry *cacheEntry) {
+	if elem, ok := m.entries[entry.key]; ok {
+		m.remove(elem)
+	}
+	m.entries[entry.key] = m.lru.PushFront(entry)
+	for _, tag := range entry.tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = make(map[string]struct{})
+		}
+		m.tags[tag][entry.key] = struct{}{}
+	}
+	for m.lru.Len() > m.opt.MaxEntries {
+		m.remove(m.lru.Back())
+	}
+}
+
+// remove removes the entry from the lru and the tags, should be called with the lock.
+func (m *CacheWare) remove(elem *list.Element) {
+	entry := m.lru.Remove(elem).(*cacheEntry)
+	delete(m.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(m.tags[tag], entry.key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
+
+func (m *CacheWare) writeEntry(w http.ResponseWriter, r *http.Request, entry *cacheEntry) {
+	header := w.Header()
+	for key, values := range entry.header {
+		header[key] = append([]string{}, values...)
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.created).Seconds())))
+	header.Set("X-Cache", "HIT")
+	w.WriteHeader(entry.status)
+	if r.Method != "HEAD" {
+		w.Write(entry.body)
+	}
+}
+
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, arg = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = arg
+	}
+	return directives
+}
+
+// cacheResponseWriter keeps a copy of the body for the cache, the response would not be cached if it is flushed,
+// hijacked or larger than the maxSize.
+type cacheResponseWriter struct {
+	ResponseWriter
+	maxSize     int
+	body        []byte
+	uncacheable bool
+}
+
+func (cw *cacheResponseWriter) Write(b []byte) (int, error) {
+	if !cw.uncacheable {
+		if len(cw.body)+len(b) > cw.maxSize {
+			cw.uncacheable = true
+			cw.body = nil
+		} else {
+			cw.body = append(cw.body, b...)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheResponseWriter) Flush() {
+	cw.uncacheable = true
+	cw.ResponseWriter.Flush()
+}
+
+func (cw *cacheResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.uncacheable = true
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+	return hijacker.Hijack()
+}
+
+func (cw *cacheResponseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := cw.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
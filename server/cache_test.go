@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCacheWare(t *testing.T) {
+	var calls int32
+	cache := NewCacheWare(CacheOptions{
+		MaxEntries:     3,
+		TTL:            time.Minute,
+		Routes:         map[string]time.Duration{"Never": -1, "Routed": time.Minute},
+		PrivateCookies: []string{"sid"},
+		Bypass: func(ctx context.Context, r *http.Request) bool {
+			return r.Header.Get("X-Bypass") != ""
+		},
+	})
+	srv := New(context.Background(), false)
+	srv.Middleware(cache)
+	page := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "public")
+		fmt.Fprintf(w, "page %s %d", Params(ctx, "id"), n)
+		return CacheTags(ctx, "pages", "page:"+Params(ctx, "id"))
+	}
+	srv.Get("/page/:id", "Page", page)
+	srv.Head("/page/:id", "HeadPage", page)
+	srv.Get("/never", "Never", page)
+	srv.Get("/nostore", "NoStore", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("nostore"))
+		return ctx
+	})
+	srv.Get("/lang", "Lang", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("lang " + r.Header.Get("Accept-Language")))
+		return ctx
+	})
+	srv.Get("/implicit", "Implicit", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("implicit"))
+		return ctx
+	})
+	srv.Get("/routed", "Routed", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte("routed"))
+		return ctx
+	})
+	srv.Get("/nonce", "Nonce", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Content-Security-Policy", "script-src 'self' 'nonce-abc'")
+		w.Write([]byte("nonce"))
+		return ctx
+	})
+
+	request := func(method, path, lang string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, nil)
+		if lang != "" {
+			r.Header.Set("Accept-Language", lang)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := request("GET", "/page/1", ""); w.Body.String() != "page 1 1" {
+				t.Errorf("Concurrent misses should be coalesced, got %q", w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Handler should be called once, got %d", calls)
+	}
+	w := request("GET", "/page/1", "")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "page 1 1" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Response should be served from the cache, %v %q", w.Header(), w.Body.String())
+	}
+	w.Header()["Content-Type"][0] = "text/html"
+	if w := request("HEAD", "/page/1", ""); w.Header().Get("X-Cache") != "HIT" || w.Body.Len() != 0 ||
+		w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("HEAD should be served from the cached GET without body and shared headers, %v", w.Header())
+	}
+
+	if n := cache.Invalidate("page:1"); n != 1 {
+		t.Errorf("Invalidate should remove 1 response, got %d", n)
+	}
+	if w := request("GET", "/page/1", ""); w.Body.String() != "page 1 2" {
+		t.Errorf("Invalidated response should be refreshed, got %q", w.Body.String())
+	}
+
+	calls = 0
+	request("GET", "/never", "")
+	request("GET", "/never", "")
+	request("GET", "/nostore", "")
+	request("GET", "/nostore", "")
+	if calls != 4 {
+		t.Errorf("Never cached route and no-store response should not be cached, calls %d", calls)
+	}
+
+	calls = 0
+	request("GET", "/implicit", "")
+	request("GET", "/implicit", "")
+	request("GET", "/nonce", "")
+	request("GET", "/nonce", "")
+	if calls != 4 {
+		t.Errorf("Not public response and response with CSP nonce should not be cached, calls %d", calls)
+	}
+	calls = 0
+	request("GET", "/routed", "")
+	if w := request("GET", "/routed", ""); w.Header().Get("X-Cache") != "HIT" || calls != 1 {
+		t.Errorf("Response of the route with ttl should be cached, calls %d", calls)
+	}
+	if w := request("GET", "/page/1", "", "Cookie", "sid=1"); w.Header().Get("X-Cache") == "HIT" {
+		t.Errorf("Request with private cookies should not be served from the cache")
+	}
+	if w := request("GET", "/page/1", "", "Cookie", "theme=dark"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Request with other cookies should be served from the cache")
+	}
+	if w := request("GET", "/page/1", "", "X-Bypass", "1"); w.Header().Get("X-Cache") == "HIT" {
+		t.Errorf("Bypassed request should not be served from the cache")
+	}
+	if w := request("GET", "/page/1", "", "Authorization", "Bearer x"); w.Header().Get("X-Cache") == "HIT" {
+		t.Errorf("Request with credentials should not be served from the cache")
+	}
+
+	calls = 0
+	request("GET", "/lang", "en")
+	request("GET", "/lang", "fr")
+	if w := request("GET", "/lang", "en"); w.Body.String() != "lang en" || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Vary variant should be cached, %q %v", w.Body.String(), w.Header())
+	}
+	if w := request("GET", "/lang", "fr"); w.Body.String() != "lang fr" || calls != 2 {
+		t.Errorf("Vary variants should be kept apart, %q calls %d", w.Body.String(), calls)
+	}
+
+	for i := 2; i <= 5; i++ {
+		request("GET", fmt.Sprintf("/page/%d", i), "")
+	}
+	if cache.Len() != 3 {
+		t.Errorf("Cache should be bounded by MaxEntries, got %d", cache.Len())
+	}
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("Purge should remove all, got %d", cache.Len())
+	}
+}
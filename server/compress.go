@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kCompressMinSize = 1024
+)
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}}
+	zlibPool = sync.Pool{New: func() interface{} {
+		w, _ := zlib.NewWriterLevel(nil, flate.DefaultCompression)
+		return w
+	}}
+
+	// the content types which are compressed already
+	compressedTypes = []string{
+		"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-compress", "application/x-7z-compressed", "application/x-rar-compressed",
+		"application/x-bzip2", "application/x-xz", "application/zstd", "application/octet-stream",
+	}
+)
+
+// CompressWare is the middleware compressing the responses with gzip or deflate by the Accept-Encoding, the small
+// bodies and the already compressed content types would be skipped.
+type CompressWare struct {
+	minSize int
+}
+
+// ServeHTTP implements the Middleware interface. The inner middlewares would see the raw size of the response, and
+// the outer ones can get the raw size by RawSize() besides the compressed Size().
+func (m *CompressWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == "HEAD" {
+		return next(ctx, w, r)
+	}
+	cw := &compressResponseWriter{
+		ResponseWriter: w.(ResponseWriter),
+		encoding:       encoding,
+		minSize:        m.minSize,
+	}
+	defer cw.finish()
+	return next(ctx, cw, r)
+}
+
+// NewCompressWare returns a new CompressWare, the bodies smaller than the minSize would not be compressed, default is 1KB.
+func NewCompressWare(minSize ...int) Middleware {
+	size := kCompressMinSize
+	if len(minSize) > 0 && minSize[0] >= 0 {
+		size = minSize[0]
+	}
+	return &CompressWare{size}
+}
+
+// negotiateEncoding selects gzip or deflate by the q values, gzip is preferred if equal.
+func negotiateEncoding(acceptEncoding string) string {
+	qValues := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if coding != "" {
+			qValues[coding] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qValues[coding]
+		if !ok {
+			q, ok = qValues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressResponseWriter buffers the body until the minSize to decide the compression, the status and headers
+// would be written to the underlying ResponseWriter after the decision.
+type compressResponseWriter struct {
+	ResponseWriter
+	encoding   string
+	minSize    int
+	status     int
+	size       int
+	buf        []byte
+	decided    bool
+	hijacked   bool
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (cw *compressResponseWriter) WriteHeader(s int) {
+	if cw.status == 0 {
+		cw.status = s
+	}
+	if s < 200 || s == http.StatusNoContent || s == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.size += len(b)
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.minSize {
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressResponseWriter) Status() int {
+	return cw.status
+}
+
+func (cw *compressResponseWriter) Written() bool {
+	return cw.status != 0
+}
+
+// Size returns the raw size of the response body written by the handlers.
+func (cw *compressResponseWriter) Size() int {
+	return cw.size
+}
+
+// RawSize returns the raw size of the response body as the Size.
+func (cw *compressResponseWriter) RawSize() int {
+	return cw.size
+}
+
+// Flush writes out the buffered body, the compression would be decided by the content type for the streaming.
+func (cw *compressResponseWriter) Flush() {
+	if cw.hijacked {
+		return
+	}
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.decide(true)
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		cw.hijacked = true
+		cw.decided = true
+		cw.status = cw.ResponseWriter.Status()
+	}
+	return conn, brw, err
+}
+
+func (cw *compressResponseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := cw.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// decide writes the status and headers, compresses the body if allowed, and writes out the buffered body.
+func (cw *compressResponseWriter) decide(allowed bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Encoding") == "" {
+		header.Add("Vary", "Accept-Encoding")
+		if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+			header.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		if allowed && isCompressible(header.Get("Content-Type")) {
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			if cw.encoding == "gzip" {
+				cw.compressor = gzipPool.Get().(*gzip.Writer)
+			} else {
+				cw.compressor = zlibPool.Get().(*zlib.Writer)
+			}
+			cw.compressor.Reset(cw.ResponseWriter)
+		}
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressResponseWriter) finish() {
+	if cw.hijacked || cw.status == 0 {
+		return
+	}
+	cw.decide(len(cw.buf) >= cw.minSize)
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		switch c := cw.compressor.(type) {
+		case *gzip.Writer:
+			gzipPool.Put(c)
+		case *zlib.Writer:
+			zlibPool.Put(c)
+		}
+		cw.compressor = nil
+	}
+	if setter, ok := cw.ResponseWriter.(rawSizeSetter); ok {
+		setter.setRawSize(cw.size)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                           "",
+		"gzip, deflate":              "gzip",
+		"deflate":                    "deflate",
+		"gzip;q=0.5, deflate;q=0.8":  "deflate",
+		"gzip;q=0, identity":         "",
+		"*":                          "gzip",
+		"br, *;q=0.1, gzip;q=0":      "deflate",
+		"identity;q=1, DEFLATE;q=.3": "deflate",
+	}
+	for accept, expected := range cases {
+		if encoding := negotiateEncoding(accept); encoding != expected {
+			t.Errorf("Accept-Encoding %q should negotiate %q, got %q", accept, expected, encoding)
+		}
+	}
+}
+
+func TestCompressWare(t *testing.T) {
+	large := strings.Repeat("sweb compress ", 200)
+	var rawSize, size int
+	srv := New(context.Background(), false)
+	srv.Middleware(MiddleFn(func(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+		ctx = next(ctx, w, r)
+		res := w.(ResponseWriter)
+		rawSize, size = res.(interface {
+			RawSize() int
+		}).RawSize(), res.Size()
+		return ctx
+	}))
+	srv.Middleware(NewCompressWare())
+	srv.Get("/large", "Large", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.(ResponseWriter).Before(func(ResponseWriter) {
+			w.Header().Set("X-Before", "yes")
+		})
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "2800")
+		w.Write([]byte(large[:100]))
+		w.Write([]byte(large[100:]))
+		return ctx
+	})
+	srv.Get("/small", "Small", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("small"))
+		return ctx
+	})
+	srv.Get("/image", "Image", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(large))
+		return ctx
+	})
+	srv.Get("/flush", "Flush", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: 2\n\n"))
+		return ctx
+	})
+
+	request := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("/large", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Large response should be gzipped with Vary, headers %v", w.Header())
+	}
+	if w.Header().Get("Content-Length") != "" || w.Header().Get("X-Before") != "yes" {
+		t.Errorf("Content-Length should be removed and before hooks called, headers %v", w.Header())
+	}
+	bodySize := w.Body.Len()
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to read the gzip body, %s", err)
+	}
+	if body, _ := ioutil.ReadAll(gz); string(body) != large {
+		t.Errorf("Gzip body mismatched, got %d bytes", len(body))
+	}
+	if rawSize != len(large) || size != bodySize || size >= rawSize {
+		t.Errorf("Size should be compressed and RawSize should be raw, got size=%d raw=%d", size, rawSize)
+	}
+
+	w = request("/large", "deflate")
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil || w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Large response should be deflated, headers %v, %v", w.Header(), err)
+	}
+	if body, _ := ioutil.ReadAll(zr); string(body) != large {
+		t.Errorf("Deflate body mismatched, got %d bytes", len(body))
+	}
+
+	w = request("/large", "")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+		t.Errorf("Response should not be compressed without Accept-Encoding, headers %v", w.Header())
+	}
+
+	w = request("/small", "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "small" {
+		t.Errorf("Small response should not be compressed, headers %v", w.Header())
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Small response should have Vary and sniffed Content-Type, headers %v", w.Header())
+	}
+
+	w = request("/image", "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+		t.Errorf("Compressed content types should be skipped, headers %v", w.Header())
+	}
+
+	w = request("/flush", "gzip")
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Flushed stream should be gzipped, headers %v", w.Header())
+	}
+	gz, _ = gzip.NewReader(w.Body)
+	if body, _ := ioutil.ReadAll(gz); string(body) != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("Flushed stream body mismatched, %q", body)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	kCookieTimestampSize = 8
+	kCookieMaxSize       = 4096
+)
+
+var (
+	// ErrCookieInvalid is returned if the cookie is tampered, or not encoded by any of the keys.
+	ErrCookieInvalid = errors.New("secure cookie: invalid value")
+	// ErrCookieExpired is returned if the cookie is older than the MaxAge.
+	ErrCookieExpired = errors.New("secure cookie: expired")
+	// ErrCookieTooLarge is returned if the encoded cookie is larger than the browsers accept.
+	ErrCookieTooLarge = errors.New("secure cookie: value too large")
+)
+
+// SecureCookieOptions defines the options of the SecureCookie.
+type SecureCookieOptions struct {
+	// Encrypt the values with AES-GCM, the keys must be 16, 24 or 32 bytes. The values are HMAC signed by default.
+	Encrypt bool
+	// The values older than this would be rejected, and it is also the max age of the cookies. Zero means the
+	// session cookies without the age limit.
+	MaxAge time.Duration
+	// The cookie path, default is "/"
+	Path string
+	// The cookie domain
+	Domain string
+	// The cookies are Secure by default, set Insecure for the plain http, e.g. in development
+	Insecure bool
+	// The cookies are HttpOnly by default, set AllowScripts if the javascripts need to read them
+	AllowScripts bool
+	// The SameSite attribute, default is Lax
+	SameSite http.SameSite
+}
+
+// SecureCookie encodes the cookie values with the keys, the first key signs or encrypts and all the keys are tried
+// for the decoding so that the keys can be rotated. The encoded timestamp enforces the MaxAge on the server side,
+// and the cookie name is bound so that the values can not be swapped between the cookies.
+//
+//	sc, err := server.NewSecureCookie(server.SecureCookieOptions{MaxAge: 24 * time.Hour}, newKey, oldKey)
+//	sc.Set(w, "remember", []byte(userId))
+//	value, err := sc.Get(r, "remember")
+type SecureCookie struct {
+	opt   SecureCookieOptions
+	keys  [][]byte
+	aeads []cipher.AEAD
+}
+
+// NewSecureCookie returns a new SecureCookie with the keys, the newest key goes first.
+func NewSecureCookie(opt SecureCookieOptions, keys ...[]byte) (*SecureCookie, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("secure cookie: at least one key is required")
+	}
+	if opt.Path == "" {
+		opt.Path = "/"
+	}
+	if opt.SameSite == 0 {
+		opt.SameSite = http.SameSiteLaxMode
+	}
+	sc := &SecureCookie{opt: opt, keys: keys}
+	if opt.Encrypt {
+		for _, key := range keys {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, fmt.Errorf("secure cookie: %s", err)
+			}
+			aead, err := cipher.NewGCM(block)
+			if err != nil {
+				return nil, fmt.Errorf("secure cookie: %s", err)
+			}
+			sc.aeads = append(sc.aeads, aead)
+		}
+	}
+	return sc, nil
+}
+
+// Encode encodes the value for the cookie name.
+func (sc *SecureCookie) Encode(name string, value []byte) (string, error) {
+	payload := make([]byte, kCookieTimestampSize, kCookieTimestampSize+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, value...)
+
+	var data []byte
+	if sc.opt.Encrypt {
+		aead := sc.aeads[0]
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = aead.Seal(nonce, nonce, payload, []byte(name))
+	} else {
+		data = append(payload, sc.sign(sc.keys[0], name, payload)...)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	if len(name)+len(encoded) > kCookieMaxSize {
+		return "", ErrCookieTooLarge
+	}
+	return encoded, nil
+}
+
+// Decode decodes the cookie value of the name, verifies the signature and the age.
+func (sc *SecureCookie) Decode(name string, encoded string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrCookieInvalid
+	}
+	payload, err := sc.open(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < kCookieTimestampSize {
+		return nil, ErrCookieInvalid
+	}
+	timestamp := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if sc.opt.MaxAge > 0 && time.Since(timestamp) > sc.opt.MaxAge {
+		return nil, ErrCookieExpired
+	}
+	return payload[kCookieTimestampSize:], nil
+}
+
+// Set encodes the value and sets the cookie to the response.
+func (sc *SecureCookie) Set(w http.ResponseWriter, name string, value []byte) error {
+	encoded, err := sc.Encode(name, value)
+	if err != nil {
+		return err
+	}
+	cookie := sc.cookie(name, encoded)
+	if sc.opt.MaxAge > 0 {
+		cookie.MaxAge = int(sc.opt.MaxAge.Seconds())
+		cookie.Expires = time.Now().Add(sc.opt.MaxAge)
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// Get returns the decoded cookie value of the request, http.ErrNoCookie if not found.
+func (sc *SecureCookie) Get(r *http.Request, name string) ([]byte, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return sc.Decode(name, cookie.Value)
+}
+
+// Delete deletes the cookie from the client.
+func (sc *SecureCookie) Delete(w http.ResponseWriter, name string) {
+	cookie := sc.cookie(name, "")
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(1, 0)
+	http.SetCookie(w, cookie)
+}
+
+func (sc *SecureCookie) cookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     sc.opt.Path,
+		Domain:   sc.opt.Domain,
+		Secure:   !sc.opt.Insecure,
+		HttpOnly: !sc.opt.AllowScripts,
+		SameSite: sc.opt.SameSite,
+	}
+}
+
+func (sc *SecureCookie) open(name string, data []byte) ([]byte, error) {
+	if sc.opt.Encrypt {
+		for _, aead := range sc.aeads {
+			if len(data) < aead.NonceSize() {
+				return nil, ErrCookieInvalid
+			}
+			nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+			if payload, err := aead.Open(nil, nonce, sealed, []byte(name)); err == nil {
+				return payload, nil
+			}
+		}
+		return nil, ErrCookieInvalid
+	}
+	if len(data) < sha256.Size {
+		return nil, ErrCookieInvalid
+	}
+	payload, signature := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	for _, key := range sc.keys {
+		if hmac.Equal(signature, sc.sign(key, name, payload)) {
+			return payload, nil
+		}
+	}
+	return nil, ErrCookieInvalid
+}
+
+func (sc *SecureCookie) sign(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecureCookie(t *testing.T) {
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	for _, encrypt := range []bool{false, true} {
+		old, err := NewSecureCookie(SecureCookieOptions{Encrypt: encrypt}, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc, _ := NewSecureCookie(SecureCookieOptions{Encrypt: encrypt, MaxAge: time.Hour}, newKey, oldKey)
+
+		encoded, _ := old.Encode("user", []byte("mijia"))
+		if value, err := sc.Decode("user", encoded); err != nil || string(value) != "mijia" {
+			t.Errorf("Value encoded by the old key should be decoded, %q %v", value, err)
+		}
+		if _, err := old.Decode("user", mustEncode(sc, "user", "mijia")); err != ErrCookieInvalid {
+			t.Errorf("Value encoded by the new key should not be decoded by the old, %v", err)
+		}
+		if _, err := sc.Decode("admin", encoded); err != ErrCookieInvalid {
+			t.Errorf("Value should be bound to the cookie name, %v", err)
+		}
+		tampered := []byte(encoded)
+		tampered[10] ^= 1
+		if _, err := sc.Decode("user", string(tampered)); err != ErrCookieInvalid {
+			t.Errorf("Tampered value should be rejected, %v", err)
+		}
+	}
+
+	sc, _ := NewSecureCookie(SecureCookieOptions{MaxAge: time.Second}, oldKey)
+	w := httptest.NewRecorder()
+	if err := sc.Set(w, "remember", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != 1 {
+		t.Errorf("Cookie should have the secure defaults, %+v", cookie)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	if value, err := sc.Get(r, "remember"); err != nil || string(value) != "1" {
+		t.Errorf("Cookie should be read, %q %v", value, err)
+	}
+	payload := make([]byte, 9)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Add(-time.Minute).Unix()))
+	payload[8] = '1'
+	expired := base64.RawURLEncoding.EncodeToString(append(payload, sc.sign(oldKey, "remember", payload)...))
+	if _, err := sc.Decode("remember", expired); err != ErrCookieExpired {
+		t.Errorf("Cookie older than the MaxAge should be rejected, %v", err)
+	}
+
+	if _, err := NewSecureCookie(SecureCookieOptions{Encrypt: true}, []byte("short")); err == nil {
+		t.Errorf("Invalid AES key should be rejected")
+	}
+}
+
+func mustEncode(sc *SecureCookie, name, value string) string {
+	encoded, _ := sc.Encode(name, []byte(value))
+	return encoded
+}
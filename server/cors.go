@@ -0,0 +1,190 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+var (
+	corsDefaultMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+	corsDefaultHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization", "X-Requested-With"}
+)
+
+// CORSOptions defines the options of the CORSWare.
+type CORSOptions struct {
+	// The allowed origins, "*" allows all, and "https://*.example.com" allows the subdomains
+	AllowedOrigins []string
+	// The predicate for the origins, checked if the origin is not in the AllowedOrigins
+	AllowOriginFunc func(origin string) bool
+	// The allowed methods of the preflights, default is GET, POST, PUT, PATCH, DELETE and HEAD
+	AllowedMethods []string
+	// The allowed request headers of the preflights, "*" allows all, default is the common ones
+	AllowedHeaders []string
+	// The response headers exposed to the clients
+	ExposedHeaders []string
+	// If the credentials (cookies, authorization) are allowed, only for the origins listed or allowed by the func,
+	// the "*" never allows the credentials
+	AllowCredentials bool
+	// How long the preflight results can be cached
+	MaxAge time.Duration
+}
+
+// CORSWare is the Cross-Origin Resource Sharing middleware, it answers the preflight requests and adds the CORS
+// headers to the actual requests from the allowed origins.
+type CORSWare struct {
+	opt        CORSOptions
+	methods    map[string]bool
+	headers    map[string]bool
+	varyOrigin bool
+	lookup     func(method, path string) bool
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *CORSWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	header := w.Header()
+	if m.varyOrigin {
+		// the responses without the Origin differ as well for the shared caches
+		header.Add("Vary", "Origin")
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return next(ctx, w, r)
+	}
+	isPreflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+	if isPreflight && (m.lookup == nil || !m.lookup("OPTIONS", r.URL.Path)) {
+		m.preflight(w, r, origin)
+		return ctx
+	}
+	if m.allowOrigin(origin) {
+		m.setOrigin(header, origin)
+		if len(m.opt.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(m.opt.ExposedHeaders, ", "))
+		}
+	}
+	return next(ctx, w, r)
+}
+
+// NewCORSWare returns a new CORSWare.
+func NewCORSWare(opt CORSOptions) *CORSWare {
+	if len(opt.AllowedMethods) == 0 {
+		opt.AllowedMethods = corsDefaultMethods
+	}
+	if len(opt.AllowedHeaders) == 0 {
+		opt.AllowedHeaders = corsDefaultHeaders
+	}
+	m := &CORSWare{
+		opt:     opt,
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, method := range opt.AllowedMethods {
+		m.methods[strings.ToUpper(method)] = true
+	}
+	for _, name := range opt.AllowedHeaders {
+		m.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	// the origin is reflected unless only "*" is sent
+	listed := len(opt.AllowedOrigins) > 1 || opt.AllowOriginFunc != nil
+	m.varyOrigin = !m.allowAnyOrigin() || (opt.AllowCredentials && listed)
+	if m.allowAnyOrigin() && opt.AllowCredentials {
+		log.Warnf("[CORSWare] The credentials are not allowed for the any origin \"*\"")
+	}
+	return m
+}
+
+// EnableCORS handles the CORS before the routing, so that the preflights are answered even if there is no OPTIONS
+// route, and the CORS headers are added to the NotFound and MethodNotAllowed responses as well. The OPTIONS routes
+// registered would still handle their preflights.
+func (s *Server) EnableCORS(opt CORSOptions) {
+	s.cors = NewCORSWare(opt)
+	s.cors.lookup = func(method, path string) bool {
+		handle, _, _ := s.router.Lookup(method, path)
+		return handle != nil
+	}
+}
+
+func (m *CORSWare) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requestHeaders := headerValues(r.Header, "Access-Control-Request-Headers")
+	if m.allowOrigin(origin) && m.methods[method] && m.allowHeaders(requestHeaders) {
+		m.setOrigin(header, origin)
+		header.Set("Access-Control-Allow-Methods", strings.Join(m.opt.AllowedMethods, ", "))
+		if len(requestHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+		}
+		if m.opt.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(m.opt.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *CORSWare) setOrigin(header http.Header, origin string) {
+	if m.opt.AllowCredentials && m.allowListedOrigin(origin) {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else if m.allowAnyOrigin() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func (m *CORSWare) allowAnyOrigin() bool {
+	for _, allowed := range m.opt.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CORSWare) allowOrigin(origin string) bool {
+	return m.allowAnyOrigin() || m.allowListedOrigin(origin)
+}
+
+// allowListedOrigin tells if the origin is allowed other than by the "*".
+func (m *CORSWare) allowListedOrigin(origin string) bool {
+	for _, allowed := range m.opt.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) || matchWildcardOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return m.opt.AllowOriginFunc != nil && m.opt.AllowOriginFunc(origin)
+}
+
+func (m *CORSWare) allowHeaders(names []string) bool {
+	if m.headers["*"] {
+		return true
+	}
+	for _, name := range names {
+		if !m.headers[http.CanonicalHeaderKey(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcardOrigin matches the origin like "https://api.example.com" with the pattern "https://*.example.com".
+func matchWildcardOrigin(pattern, origin string) bool {
+	i := strings.Index(pattern, "://*.")
+	if i < 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Scheme, pattern[:i]) {
+		return false
+	}
+	suffix := strings.ToLower(pattern[i+4:])
+	host := strings.ToLower(u.Host)
+	return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+}
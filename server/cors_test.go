@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMatchWildcardOrigin(t *testing.T) {
+	cases := []struct {
+		pattern, origin string
+		matched         bool
+	}{
+		{"https://*.example.com", "https://api.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "http://api.example.com", false},
+		{"https://*.example.com", "https://api.example.com.evil.io", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://example.com", "https://api.example.com", false},
+	}
+	for _, c := range cases {
+		if matched := matchWildcardOrigin(c.pattern, c.origin); matched != c.matched {
+			t.Errorf("Origin %q with pattern %q should be %v", c.origin, c.pattern, c.matched)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.EnableCORS(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowOriginFunc:  func(origin string) bool { return strings.HasSuffix(origin, ".local") },
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	srv.Get("/items", "Items", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("items"))
+		return ctx
+	})
+	srv.Handle("OPTIONS", "/custom", "Custom", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("custom"))
+		return ctx
+	})
+
+	request := func(method, path, origin string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, nil)
+		r.Header.Set("Origin", origin)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	preflight := map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "content-type, x-token"}
+	w := request("OPTIONS", "/items", "https://app.example.com", preflight)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("Preflight should be answered without OPTIONS route, %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Headers") != "content-type, x-token" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "PUT") {
+		t.Errorf("Preflight headers mismatched, %v", w.Header())
+	}
+
+	for _, origin := range []string{"https://api.example.org", "http://dev.local"} {
+		if w := request("OPTIONS", "/items", origin, preflight); w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("Origin %q should be allowed, %v", origin, w.Header())
+		}
+	}
+	if w := request("OPTIONS", "/items", "https://evil.com", preflight); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unknown origin should not be allowed, %v", w.Header())
+	}
+	badHeaders := map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Other"}
+	if w := request("OPTIONS", "/items", "https://app.example.com", badHeaders); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unknown request headers should not be allowed, %v", w.Header())
+	}
+
+	w = request("GET", "/items", "https://app.example.com", nil)
+	if w.Body.String() != "items" || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Expose-Headers") != "X-Total" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Actual request should have CORS headers, %v", w.Header())
+	}
+	if w := request("OPTIONS", "/custom", "https://app.example.com", preflight); w.Body.String() != "custom" {
+		t.Errorf("OPTIONS route should handle its preflight, got %q", w.Body.String())
+	}
+	if w := request("GET", "/items", "", nil); w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Response without the Origin should vary by the Origin, %v", w.Header())
+	}
+
+	anySrv := New(context.Background(), false)
+	anySrv.EnableCORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	anySrv.Get("/items", "Items", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		return ctx
+	})
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/items", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	anySrv.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" ||
+		w.Header().Get("Vary") != "" {
+		t.Errorf("Any origin should not be allowed with the credentials, %v", w.Header())
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kCSRFKey = "inter_ctx_key_csrf"
+
+	kCSRFTokenSize  = 32
+	kCSRFCookieName = "_csrf"
+	kCSRFHeaderName = "X-CSRF-Token"
+	kCSRFFieldName  = "csrf_token"
+)
+
+// CSRFOptions defines the options of the CSRFWare.
+type CSRFOptions struct {
+	// The secret signing the token cookie, so that the cookies planted by others would be rejected
+	Secret []byte
+	// The cookie keeping the token, default is "_csrf"
+	CookieName string
+	// The cookie path, default is "/"
+	CookiePath string
+	// The cookie domain
+	CookieDomain string
+	// If the cookie is only sent over https
+	Secure bool
+	// The max age of the cookie, zero means the session cookie
+	MaxAge time.Duration
+	// The request header carrying the token, default is "X-CSRF-Token"
+	HeaderName string
+	// The form field carrying the token, default is "csrf_token"
+	FieldName string
+	// The route names which are not protected, e.g. the webhooks
+	ExemptRoutes []string
+}
+
+// CSRFWare is the double-submit CSRF middleware, the token is kept in the cookie, and the unsafe requests must submit
+// the token by the header or form field. The tokens for the pages are masked for each request against BREACH.
+//
+// In the templates, with the DefaultRouteFuncs and the request context in the binding:
+//
+//	<form method="POST">{{ csrfField .Ctx }} ... </form>
+//	<head>{{ csrfMeta .Ctx }}</head>
+type CSRFWare struct {
+	opt    CSRFOptions
+	exempt map[string]bool
+}
+
+type csrfContext struct {
+	token []byte
+	opt   *CSRFOptions
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *CSRFWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	token := m.cookieToken(r)
+	isNew := token == nil
+	if isNew {
+		token = make([]byte, kCSRFTokenSize)
+		if _, err := rand.Read(token); err != nil {
+			WriteProblem(w, http.StatusInternalServerError, "Failed to generate the CSRF token")
+			return ctx
+		}
+		m.setCookie(w, token)
+	}
+	w.Header().Add("Vary", "Cookie")
+	ctx = context.WithValue(ctx, kCSRFKey, &csrfContext{token, &m.opt})
+
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return next(ctx, w, r)
+	}
+	if m.exempt[RouteName(ctx)] {
+		return next(ctx, w, r)
+	}
+	submitted := r.Header.Get(m.opt.HeaderName)
+	if submitted == "" {
+		submitted = r.PostFormValue(m.opt.FieldName)
+	}
+	if isNew || !validCSRFToken(token, submitted) {
+		WriteProblem(w, http.StatusForbidden, "CSRF token is missing or invalid")
+		return ctx
+	}
+	return next(ctx, w, r)
+}
+
+// NewCSRFWare returns a new CSRFWare.
+func NewCSRFWare(opt CSRFOptions) Middleware {
+	if opt.CookieName == "" {
+		opt.CookieName = kCSRFCookieName
+	}
+	if opt.CookiePath == "" {
+		opt.CookiePath = "/"
+	}
+	if opt.HeaderName == "" {
+		opt.HeaderName = kCSRFHeaderName
+	}
+	if opt.FieldName == "" {
+		opt.FieldName = kCSRFFieldName
+	}
+	m := &CSRFWare{
+		opt:    opt,
+		exempt: make(map[string]bool),
+	}
+	for _, name := range opt.ExemptRoutes {
+		m.exempt[name] = true
+	}
+	return m
+}
+
+// CSRFToken returns the masked CSRF token of the request, empty if the CSRFWare is not used.
+func CSRFToken(ctx context.Context) string {
+	csrf, ok := ctx.Value(kCSRFKey).(*csrfContext)
+	if !ok {
+		return ""
+	}
+	return maskCSRFToken(csrf.token)
+}
+
+// CSRFField returns the hidden input of the CSRF token for the html forms.
+func CSRFField(ctx context.Context) template.HTML {
+	csrf, ok := ctx.Value(kCSRFKey).(*csrfContext)
+	if !ok {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(csrf.opt.FieldName), maskCSRFToken(csrf.token)))
+}
+
+// CSRFMeta returns the meta tags of the CSRF token and header name for the javascript clients.
+func CSRFMeta(ctx context.Context) template.HTML {
+	csrf, ok := ctx.Value(kCSRFKey).(*csrfContext)
+	if !ok {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<meta name="csrf-token" content="%s"><meta name="csrf-header" content="%s">`,
+		maskCSRFToken(csrf.token), template.HTMLEscapeString(csrf.opt.HeaderName)))
+}
+
+func (m *CSRFWare) cookieToken(r *http.Request) []byte {
+	cookie, err := r.Cookie(m.opt.CookieName)
+	if err != nil {
+		return nil
+	}
+	value := cookie.Value
+	if len(m.opt.Secret) > 0 {
+		i := strings.LastIndex(value, ".")
+		if i < 0 || !hmac.Equal([]byte(value[i+1:]), []byte(m.sign(value[:i]))) {
+			return nil
+		}
+		value = value[:i]
+	}
+	token, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(token) != kCSRFTokenSize {
+		return nil
+	}
+	return token
+}
+
+func (m *CSRFWare) setCookie(w http.ResponseWriter, token []byte) {
+	value := base64.RawURLEncoding.EncodeToString(token)
+	if len(m.opt.Secret) > 0 {
+		value += "." + m.sign(value)
+	}
+	cookie := &http.Cookie{
+		Name:     m.opt.CookieName,
+		Value:    value,
+		Path:     m.opt.CookiePath,
+		Domain:   m.opt.CookieDomain,
+		Secure:   m.opt.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if m.opt.MaxAge > 0 {
+		cookie.MaxAge = int(m.opt.MaxAge.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (m *CSRFWare) sign(value string) string {
+	mac := hmac.New(sha256.New, m.opt.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// maskCSRFToken returns the one-time-pad and the token xor-ed with it, encoded in base64.
+func maskCSRFToken(token []byte) string {
+	masked := make([]byte, 2*len(token))
+	if _, err := rand.Read(masked[:len(token)]); err != nil {
+		return ""
+	}
+	for i, b := range token {
+		masked[len(token)+i] = masked[i] ^ b
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func validCSRFToken(token []byte, submitted string) bool {
+	masked, err := base64.RawURLEncoding.DecodeString(submitted)
+	if err != nil || len(masked) != 2*len(token) {
+		return false
+	}
+	unmasked := make([]byte, len(token))
+	for i := range unmasked {
+		unmasked[i] = masked[i] ^ masked[len(token)+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, token) == 1
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCSRFWare(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Middleware(NewCSRFWare(CSRFOptions{Secret: []byte("secret"), ExemptRoutes: []string{"Hook"}}))
+	tmpl := template.Must(template.New("form").Funcs(srv.DefaultRouteFuncs()).Parse(
+		`<form>{{ csrfField .Ctx }}</form>{{ csrfMeta .Ctx }}`))
+	srv.Get("/form", "Form", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		tmpl.Execute(w, map[string]interface{}{"Ctx": ctx})
+		return ctx
+	})
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("ok"))
+		return ctx
+	}
+	srv.Post("/form", "Submit", ok)
+	srv.Post("/hook", "Hook", ok)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/form", nil)
+	srv.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "_csrf" || !cookies[0].HttpOnly {
+		t.Fatalf("Token cookie should be set, %v", cookies)
+	}
+	matches := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if matches == nil || !strings.Contains(w.Body.String(), `<meta name="csrf-header" content="X-CSRF-Token">`) {
+		t.Fatalf("Template should render the field and meta, %s", w.Body.String())
+	}
+	token := matches[1]
+
+	submit := func(path string, cookie *http.Cookie, field, header string) int {
+		w := httptest.NewRecorder()
+		form := url.Values{}
+		if field != "" {
+			form.Set("csrf_token", field)
+		}
+		r, _ := http.NewRequest("POST", path, bytes.NewBufferString(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := submit("/form", cookies[0], token, ""); code != 200 {
+		t.Errorf("Form with the token should be accepted, got %d", code)
+	}
+	ctx := context.WithValue(context.Background(), kCSRFKey, &csrfContext{token: []byte("0123456789abcdef0123456789abcdef")})
+	if CSRFToken(context.Background()) != "" || CSRFToken(ctx) == CSRFToken(ctx) {
+		t.Errorf("Tokens should be masked per call")
+	}
+	if code := submit("/form", cookies[0], "", token); code != 200 {
+		t.Errorf("Header with the token should be accepted, got %d", code)
+	}
+	if code := submit("/form", cookies[0], "", ""); code != http.StatusForbidden {
+		t.Errorf("Missing token should be rejected, got %d", code)
+	}
+	if code := submit("/form", nil, token, ""); code != http.StatusForbidden {
+		t.Errorf("Missing cookie should be rejected, got %d", code)
+	}
+	forged := &http.Cookie{Name: "_csrf", Value: strings.Split(cookies[0].Value, ".")[0] + ".forged"}
+	if code := submit("/form", forged, token, ""); code != http.StatusForbidden {
+		t.Errorf("Unsigned cookie should be rejected, got %d", code)
+	}
+	if code := submit("/hook", nil, "", ""); code != 200 {
+		t.Errorf("Exempt route should be accepted, got %d", code)
+	}
+}
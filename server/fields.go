@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kFieldsQuery = "fields"
+	kExpandQuery = "expand"
+	kExpandKey   = "inter_ctx_key_expand"
+)
+
+// Expander is an optional interface for a Resource to declare the relations which can be expanded by the "expand"
+// query, e.g. "?expand=owner". The handlers would check server.Expanded(ctx, "owner") to load the relation.
+type Expander interface {
+	Expandable() []string
+}
+
+// Expanded tells if the relation is requested to be expanded by the "expand" query of the restful request.
+func Expanded(ctx context.Context, relation string) bool {
+	relations, ok := ctx.Value(kExpandKey).(map[string]bool)
+	return ok && relations[relation]
+}
+
+// shapeHandler wraps the resource handler for any restful handler adapter, validates the "expand" query against the
+// relations declared by the resource and injects the expanded relations into the context, then prunes the successful
+// data to the "fields" query. The items of the stream data would be pruned while streaming.
+func shapeHandler(resource Resource, handle ResourceHandler) ResourceHandler {
+	return func(ctx context.Context, r *http.Request) (int, interface{}) {
+		query := r.URL.Query()
+		if expand := query.Get(kExpandQuery); expand != "" {
+			declared := make(map[string]bool)
+			if expander, ok := resource.(Expander); ok {
+				for _, relation := range expander.Expandable() {
+					declared[relation] = true
+				}
+			}
+			relations := make(map[string]bool)
+			for _, relation := range splitQueryList(expand) {
+				if !declared[relation] {
+					return http.StatusBadRequest, fmt.Sprintf("Relation %q cannot be expanded", relation)
+				}
+				relations[relation] = true
+			}
+			ctx = context.WithValue(ctx, kExpandKey, relations)
+		}
+		status, v := handle(ctx, r)
+		fields := query.Get(kFieldsQuery)
+		if fields == "" || status < 200 || status >= 300 || isStreamData(v) {
+			return status, v
+		}
+		shaped, err := ShapeFields(v, fields)
+		if err != nil {
+			return http.StatusBadRequest, err.Error()
+		}
+		if locator, ok := v.(Locator); ok {
+			return status, locatedData{locator, shaped}
+		}
+		return status, shaped
+	}
+}
+
+// locatedData keeps the Locator of the data after it is shaped.
+type locatedData struct {
+	Locator
+	data interface{}
+}
+
+func (d locatedData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.data)
+}
+
+type fieldTree map[string]fieldTree
+
+// ShapeFields prunes the data to the requested sparse fieldset, e.g. "id,name,owner.email". Fields are matched
+// against the json names of the data, an unknown field would be reported as an error. The fields under the maps and
+// interface values are matched against the keys of the data.
+func ShapeFields(v interface{}, fields string) (interface{}, error) {
+	tree := parseFieldTree(fields)
+	if len(tree) == 0 {
+		return v, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	if err := validateFields(reflect.TypeOf(v), generic, tree, ""); err != nil {
+		return nil, err
+	}
+	return pruneFields(generic, tree), nil
+}
+
+func parseFieldTree(fields string) fieldTree {
+	tree := make(fieldTree)
+	for _, field := range splitQueryList(fields) {
+		node := tree
+		for _, part := range strings.Split(field, ".") {
+			if part == "" {
+				break
+			}
+			child, ok := node[part]
+			if !ok {
+				child = make(fieldTree)
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// validateFields checks the field tree against the struct types, the maps and the dynamic values are checked against
+// the generic json data since they don't declare the fields.
+func validateFields(t reflect.Type, v interface{}, tree fieldTree, prefix string) error {
+	if len(tree) == 0 {
+		return nil
+	}
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if items, ok := v.([]interface{}); ok {
+		for _, item := range items {
+			if err := validateFields(t, item, tree, prefix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	object, isObject := v.(map[string]interface{})
+	if t != nil && t.Kind() == reflect.Struct {
+		known := jsonFields(t)
+		for _, name := range sortedFieldNames(tree) {
+			fieldType, ok := known[name]
+			if !ok {
+				return fmt.Errorf("Unknown field %q", prefix+name)
+			}
+			if err := validateFields(fieldType, object[name], tree[name], prefix+name+"."); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if (t != nil && t.Kind() != reflect.Map && t.Kind() != reflect.Interface) || (v != nil && !isObject) {
+		return fmt.Errorf("Field %q does not have any sub fields", strings.TrimSuffix(prefix, "."))
+	}
+	var elemType reflect.Type
+	if t != nil && t.Kind() == reflect.Map {
+		elemType = t.Elem()
+	}
+	for _, name := range sortedFieldNames(tree) {
+		value, ok := object[name]
+		if !ok && isObject {
+			return fmt.Errorf("Unknown field %q", prefix+name)
+		}
+		if err := validateFields(elemType, value, tree[name], prefix+name+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonFields collects the json names of the struct fields as the encoding/json would marshal them.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for subName, subType := range jsonFields(embedded) {
+					if _, ok := fields[subName]; !ok {
+						fields[subName] = subType
+					}
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func pruneFields(v interface{}, tree fieldTree) interface{} {
+	if len(tree) == 0 {
+		return v
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{})
+		for name, subTree := range tree {
+			if field, ok := value[name]; ok {
+				pruned[name] = pruneFields(field, subTree)
+			}
+		}
+		return pruned
+	case []interface{}:
+		for i := range value {
+			value[i] = pruneFields(value[i], tree)
+		}
+		return value
+	}
+	return v
+}
+
+func sortedFieldNames(tree fieldTree) []string {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func splitQueryList(query string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(query, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
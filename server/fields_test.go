@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type fOwner struct {
+	Id    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+type fItem struct {
+	Id      int     `json:"id"`
+	Name    string  `json:"name"`
+	Secret  string  `json:"-"`
+	OwnerId int     `json:"owner_id"`
+	Owner   *fOwner `json:"owner,omitempty"`
+}
+
+type fItemResource struct {
+	BaseResource
+}
+
+func (res fItemResource) Expandable() []string {
+	return []string{"owner"}
+}
+
+func (res fItemResource) Get(ctx context.Context, r *http.Request) (int, interface{}) {
+	item := fItem{Id: 1, Name: "item", OwnerId: 2}
+	if Expanded(ctx, "owner") {
+		item.Owner = &fOwner{2, "owner@example.com"}
+	}
+	return http.StatusOK, []fItem{item}
+}
+
+func TestShapeFields(t *testing.T) {
+	item := fItem{Id: 1, Name: "item", Secret: "secret", Owner: &fOwner{2, "owner@example.com"}}
+	v, err := ShapeFields(item, "id, owner.email")
+	if err != nil {
+		t.Fatalf("ShapeFields failed, %s", err)
+	}
+	data, _ := json.Marshal(v)
+	if expected := `{"id":1,"owner":{"email":"owner@example.com"}}`; string(data) != expected {
+		t.Errorf("Wrong shaped data, expected=%s, got=%s", expected, data)
+	}
+
+	for _, fields := range []string{"Secret", "owner.name", "name.first"} {
+		if _, err := ShapeFields(item, fields); err == nil {
+			t.Errorf("Should reject the unknown fields %q", fields)
+		}
+	}
+
+	m := map[string]interface{}{"a": 1, "b": map[string]int{"c": 2, "d": 3}}
+	v, err = ShapeFields(m, "b.c")
+	if err != nil {
+		t.Fatalf("ShapeFields failed on map, %s", err)
+	}
+	data, _ = json.Marshal(v)
+	if expected := `{"b":{"c":2}}`; string(data) != expected {
+		t.Errorf("Wrong shaped map data, expected=%s, got=%s", expected, data)
+	}
+	for _, fields := range []string{"x", "b.x", "a.x"} {
+		if _, err := ShapeFields(m, fields); err == nil {
+			t.Errorf("Should reject the unknown map fields %q", fields)
+		}
+	}
+	wrapped := struct {
+		Data interface{} `json:"data"`
+	}{item}
+	if _, err := ShapeFields(wrapped, "data.owner.email"); err != nil {
+		t.Errorf("ShapeFields failed on the interface value, %s", err)
+	}
+	if _, err := ShapeFields(wrapped, "data.unknown"); err == nil {
+		t.Errorf("Should reject the unknown fields under the interface value")
+	}
+}
+
+func TestRestfulFieldsExpand(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.AddRestfulResource("/items", "Items", fItemResource{})
+	custom := New(context.Background(), false)
+	custom.RestfulHandlerAdapter(func(handle ResourceHandler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+			status, v := handle(ctx, r)
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(v)
+			return ctx
+		}
+	})
+	custom.AddRestfulResource("/items", "Items", fItemResource{})
+
+	cases := []struct {
+		query    string
+		status   int
+		expected string
+	}{
+		{"", http.StatusOK, `[{"id":1,"name":"item","owner_id":2}]`},
+		{"?fields=id", http.StatusOK, `[{"id":1}]`},
+		{"?fields=name,owner.email&expand=owner", http.StatusOK, `[{"name":"item","owner":{"email":"owner@example.com"}}]`},
+		{"?fields=unknown", http.StatusBadRequest, ""},
+		{"?expand=creator", http.StatusBadRequest, ""},
+	}
+	for _, s := range []*Server{srv, custom} {
+		for _, c := range cases {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "/items"+c.query, nil)
+			s.router.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Errorf("Wrong status for %q, expected=%d, got=%d", c.query, c.status, w.Code)
+				continue
+			}
+			if c.expected == "" {
+				continue
+			}
+			var got, expected interface{}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			json.Unmarshal([]byte(c.expected), &expected)
+			gotData, _ := json.Marshal(got)
+			expectedData, _ := json.Marshal(expected)
+			if string(gotData) != string(expectedData) {
+				t.Errorf("Wrong body for %q, expected=%s, got=%s", c.query, expectedData, gotData)
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+// The status of the jobs
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	kJobTTL         = time.Hour
+	kJobCancelWait  = 5 * time.Second
+	kJobSweepPeriod = time.Minute
+)
+
+// JobFunc is the function type for the long-running work, the context would be cancelled when the job is cancelled
+// or the server is shutdown. The returned result would be reported by the job status resource.
+type JobFunc func(ctx context.Context, job *Job) (interface{}, error)
+
+// Job is a long-running work submitted to the JobManager, it would be marshaled as the JobStatus.
+type Job struct {
+	manager *JobManager
+	lock    sync.RWMutex
+	status  JobStatus
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// JobStatus is the status report of a job.
+type JobStatus struct {
+	Id        string      `json:"id"`
+	Status    string      `json:"status"`
+	Progress  float64     `json:"progress"`
+	Message   string      `json:"message,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Id returns the job id.
+func (j *Job) Id() string {
+	return j.status.Id
+}
+
+// Status returns a snapshot of the job status.
+func (j *Job) Status() JobStatus {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.status
+}
+
+// SetProgress reports the progress between 0 and 1 of the running job, with an optional message.
+func (j *Job) SetProgress(progress float64, message string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.status.Progress = progress
+	j.status.Message = message
+	j.status.UpdatedAt = time.Now()
+}
+
+// Cancel cancels the job.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Done returns a channel which would be closed when the job is finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Location implements the Locator interface, reverses the job status resource.
+func (j *Job) Location() string {
+	return j.manager.srv.Reverse("Get_"+j.manager.name, j.Id())
+}
+
+// MarshalJSON marshals the job as the JobStatus.
+func (j *Job) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Status())
+}
+
+func (j *Job) finish(status string, result interface{}, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.status.Status = status
+	j.status.Result = result
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+	if status == JobSucceeded {
+		j.status.Progress = 1
+	}
+	j.status.UpdatedAt = time.Now()
+}
+
+// JobManager runs the jobs in the background and serves the job status resource, all the jobs would be cancelled
+// when the server is shutdown.
+type JobManager struct {
+	srv        *Server
+	name       string
+	ttl        time.Duration
+	cancelWait time.Duration
+	ctx        context.Context
+	cancel     context.CancelFunc
+	lock       sync.RWMutex
+	jobs       map[string]*Job
+}
+
+// EnableJobs registers the job status resource at the path like "/jobs/:id" with the given resource name, the
+// finished jobs would be kept for the ttl and swept periodically. E.g. with name "jobs", the status can be reversed by "Get_jobs".
+//
+// The restful resource can submit the job and respond 202 with the Location header:
+//
+//	job := jobs.Submit(reportJob)
+//	return http.StatusAccepted, job
+func (s *Server) EnableJobs(path string, name string, ttl time.Duration) *JobManager {
+	if ttl <= 0 {
+		ttl = kJobTTL
+	}
+	ctx, cancel := context.WithCancel(s.baseCtx)
+	m := &JobManager{
+		srv:        s,
+		name:       name,
+		ttl:        ttl,
+		cancelWait: kJobCancelWait,
+		ctx:        ctx,
+		cancel:     cancel,
+		jobs:       make(map[string]*Job),
+	}
+	s.AddRestfulResource(path, name, &jobResource{manager: m})
+	s.OnShutdown(m.Shutdown)
+	go m.sweepLoop()
+	return m
+}
+
+// Submit starts the job in the background.
+func (m *JobManager) Submit(fn JobFunc) *Job {
+	ctx, cancel := context.WithCancel(m.ctx)
+	now := time.Now()
+	job := &Job{
+		manager: m,
+		status: JobStatus{
+			Id:        newJobId(),
+			Status:    JobPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.lock.Lock()
+	m.sweep()
+	m.jobs[job.Id()] = job
+	m.lock.Unlock()
+
+	go m.run(ctx, job, fn)
+	return job
+}
+
+// Accept submits the job and responds 202 Accepted with the Location of the job status, for the normal Handler.
+func (m *JobManager) Accept(w http.ResponseWriter, fn JobFunc) *Job {
+	job := m.Submit(fn)
+	data, _ := json.MarshalIndent(job, "", "  ")
+	w.Header().Set("Location", job.Location())
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(append(data, '\n'))
+	return job
+}
+
+// Job returns the job by id, nil if not found or expired.
+func (m *JobManager) Job(id string) *Job {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.jobs[id]
+}
+
+// Shutdown cancels all the jobs, and no more jobs could be run.
+func (m *JobManager) Shutdown() {
+	m.cancel()
+}
+
+func (m *JobManager) run(ctx context.Context, job *Job, fn JobFunc) {
+	defer close(job.done)
+	defer job.cancel()
+	defer func() {
+		if err := recover(); err != nil {
+			stack := make([]byte, 1024*8)
+			stack = stack[:runtime.Stack(stack, false)]
+			log.Errorf("[JobManager] Job %s PANIC: %s\n%s", job.Id(), err, stack)
+			job.finish(JobFailed, nil, fmt.Errorf("panic: %v", err))
+		}
+	}()
+
+	if ctx.Err() != nil {
+		job.finish(JobCancelled, nil, ctx.Err())
+		return
+	}
+	job.lock.Lock()
+	job.status.Status = JobRunning
+	job.status.UpdatedAt = time.Now()
+	job.lock.Unlock()
+
+	result, err := fn(ctx, job)
+	switch {
+	case ctx.Err() != nil:
+		job.finish(JobCancelled, nil, ctx.Err())
+	case err != nil:
+		job.finish(JobFailed, nil, err)
+	default:
+		job.finish(JobSucceeded, result, nil)
+	}
+}
+
+// sweepLoop sweeps the expired jobs until the manager is shutdown, so they would not be kept without new jobs.
+func (m *JobManager) sweepLoop() {
+	period := kJobSweepPeriod
+	if m.ttl < period {
+		period = m.ttl
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.lock.Lock()
+			m.sweep()
+			m.lock.Unlock()
+		}
+	}
+}
+
+// sweep removes the expired finished jobs, should be called with the lock.
+func (m *JobManager) sweep() {
+	expired := time.Now().Add(-m.ttl)
+	for id, job := range m.jobs {
+		status := job.Status()
+		if status.Status != JobPending && status.Status != JobRunning && status.UpdatedAt.Before(expired) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// jobResource is the restful resource for the job status, the job can be cancelled by DELETE, which responds 202 if
+// the job does not stop in a while.
+type jobResource struct {
+	BaseResource
+	manager *JobManager
+}
+
+func (res *jobResource) Get(ctx context.Context, r *http.Request) (int, interface{}) {
+	job := res.manager.Job(Params(ctx, "id"))
+	if job == nil {
+		return http.StatusNotFound, "Job not found"
+	}
+	return http.StatusOK, job
+}
+
+func (res *jobResource) Delete(ctx context.Context, r *http.Request) (int, interface{}) {
+	job := res.manager.Job(Params(ctx, "id"))
+	if job == nil {
+		return http.StatusNotFound, "Job not found"
+	}
+	job.Cancel()
+	select {
+	case <-job.Done():
+		return http.StatusOK, job
+	case <-time.After(res.manager.cancelWait):
+		return http.StatusAccepted, job
+	}
+}
+
+func newJobId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestJobs(t *testing.T) {
+	srv := New(context.Background(), false)
+	jobs := srv.EnableJobs("/jobs/:id", "jobs", time.Minute)
+	release, release2 := make(chan struct{}), make(chan struct{})
+	srv.Post("/reports", "Report", srv.defaultRestfulAdapter(func(ctx context.Context, r *http.Request) (int, interface{}) {
+		return http.StatusAccepted, jobs.Submit(func(ctx context.Context, job *Job) (interface{}, error) {
+			job.SetProgress(0.5, "half")
+			<-release
+			return "report", nil
+		})
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/reports", nil)
+	srv.ServeHTTP(w, r)
+	var status JobStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if w.Code != http.StatusAccepted || status.Id == "" {
+		t.Fatalf("Should accept the job, got=%d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/jobs/"+status.Id {
+		t.Errorf("Wrong location of the job, got=%s", location)
+	}
+
+	job := jobs.Job(status.Id)
+	for i := 0; i < 100 && job.Status().Progress == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	getStatus := func() JobStatus {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/jobs/"+status.Id, nil)
+		srv.ServeHTTP(w, r)
+		var s JobStatus
+		json.Unmarshal(w.Body.Bytes(), &s)
+		return s
+	}
+	if s := getStatus(); s.Status != JobRunning || s.Progress != 0.5 || s.Message != "half" {
+		t.Errorf("Wrong running status, %+v", s)
+	}
+	close(release)
+	<-job.Done()
+	if s := getStatus(); s.Status != JobSucceeded || s.Result != "report" {
+		t.Errorf("Wrong succeeded status, %+v", s)
+	}
+
+	failed := jobs.Submit(func(ctx context.Context, job *Job) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	<-failed.Done()
+	if s := failed.Status(); s.Status != JobFailed || s.Error != "boom" {
+		t.Errorf("Wrong failed status, %+v", s)
+	}
+
+	jobs.cancelWait = 10 * time.Millisecond
+	stuck := jobs.Submit(func(ctx context.Context, job *Job) (interface{}, error) {
+		<-release2
+		return nil, nil
+	})
+	for i := 0; i < 100 && stuck.Status().Status != JobRunning; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/jobs/"+stuck.Id(), nil)
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Should respond 202 if the cancelled job does not stop in time, got=%d", w.Code)
+	}
+	close(release2)
+	<-stuck.Done()
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/jobs/"+stuck.Id(), nil)
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Should respond 200 for the stopped job, got=%d", w.Code)
+	}
+
+	blocked := jobs.Submit(func(ctx context.Context, job *Job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	srv.shutdown()
+	select {
+	case <-blocked.Done():
+		if s := blocked.Status(); s.Status != JobCancelled {
+			t.Errorf("Wrong cancelled status, %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Should cancel the jobs when the server is shutdown")
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/jobs/nosuchjob", nil)
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Should respond 404 for the unknown job, got=%d", w.Code)
+	}
+}
+
+func TestJobsSweep(t *testing.T) {
+	srv := New(context.Background(), false)
+	jobs := srv.EnableJobs("/jobs/:id", "jobs", 10*time.Millisecond)
+	defer srv.shutdown()
+	job := jobs.Submit(func(ctx context.Context, job *Job) (interface{}, error) {
+		return nil, nil
+	})
+	<-job.Done()
+	for i := 0; i < 100 && jobs.Job(job.Id()) != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if jobs.Job(job.Id()) != nil {
+		t.Errorf("Finished job should be swept after the ttl without new jobs")
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+// The standard error codes of JSON-RPC 2.0
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000
+)
+
+const (
+	kJSONRPCVersion = "2.0"
+)
+
+// JSONRPCError is the error object of JSON-RPC 2.0, the service methods can return it for the custom error codes,
+// other errors would be responded as JSONRPCServerError.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+type jsonRPCRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type jsonRPCResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type jsonRPCMethod struct {
+	fn        reflect.Value
+	argTypes  []reflect.Type
+	hasResult bool
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// JSONRPC exposes the exported methods of the service as JSON-RPC 2.0 methods at the path with the given routing name,
+// batch requests and notifications are supported. The request runs through the middlewares as the other routes.
+//
+// The service methods should be like:
+//
+//	func (s *Service) Add(ctx context.Context, a int, b int) (int, error)
+//	func (s *Service) Echo(ctx context.Context, params *EchoParams) (*EchoResult, error)
+//	func (s *Service) Ping(ctx context.Context) error
+//
+// Positional params are mapped to the arguments, named params can only be used for the methods with one argument.
+func (s *Server) JSONRPC(path string, name string, service interface{}) {
+	methods := jsonRPCMethods(service)
+	s.Post(path, name, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		serveJSONRPC(ctx, w, r, methods)
+		return ctx
+	})
+}
+
+func jsonRPCMethods(service interface{}) map[string]*jsonRPCMethod {
+	methods := make(map[string]*jsonRPCMethod)
+	value := reflect.ValueOf(service)
+	t := value.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		fnType := method.Type
+		if method.PkgPath != "" || fnType.NumIn() < 2 || fnType.In(1) != contextType {
+			continue
+		}
+		if fnType.NumOut() == 0 || fnType.NumOut() > 2 || fnType.Out(fnType.NumOut()-1) != errorType {
+			continue
+		}
+		argTypes := make([]reflect.Type, 0, fnType.NumIn()-2)
+		for j := 2; j < fnType.NumIn(); j++ {
+			argTypes = append(argTypes, fnType.In(j))
+		}
+		methods[method.Name] = &jsonRPCMethod{
+			fn:        value.Method(i),
+			argTypes:  argTypes,
+			hasResult: fnType.NumOut() == 2,
+		}
+	}
+	if len(methods) == 0 {
+		log.Warnf("Server JSON-RPC service %s has no suitable methods", t)
+	}
+	return methods
+}
+
+func serveJSONRPC(ctx context.Context, w http.ResponseWriter, r *http.Request, methods map[string]*jsonRPCMethod) {
+	data, err := readLimitedBody(r)
+	r.Body.Close()
+	if err == ErrBodyTooLarge {
+		WriteProblem(w, http.StatusRequestEntityTooLarge, "Request body exceeds the size limit")
+		return
+	}
+	if err != nil {
+		writeJSONRPC(w, jsonRPCErrorResponse(nil, JSONRPCParseError, err.Error()))
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		writeJSONRPC(w, jsonRPCErrorResponse(nil, JSONRPCParseError, "Parse error"))
+		return
+	}
+	if data[0] != '[' {
+		// the valid json which is not a request object, e.g. the wrong member types
+		var req jsonRPCRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			writeJSONRPC(w, jsonRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request"))
+			return
+		}
+		if res := callJSONRPC(ctx, req, methods); res != nil {
+			writeJSONRPC(w, res)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+		writeJSONRPC(w, jsonRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request"))
+		return
+	}
+	responses := make([]*jsonRPCResponse, 0, len(batch))
+	for _, raw := range batch {
+		var req jsonRPCRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			responses = append(responses, jsonRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request"))
+			continue
+		}
+		if res := callJSONRPC(ctx, req, methods); res != nil {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONRPC(w, responses)
+}
+
+// callJSONRPC calls the method of the request, returns nil for the notifications.
+func callJSONRPC(ctx context.Context, req jsonRPCRequest, methods map[string]*jsonRPCMethod) *jsonRPCResponse {
+	isNotification := req.Id == nil
+	if req.Version != kJSONRPCVersion || req.Method == "" || !validJSONRPCId(req.Id) {
+		return jsonRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request")
+	}
+	method, ok := methods[req.Method]
+	if !ok {
+		if isNotification {
+			return nil
+		}
+		return jsonRPCErrorResponse(req.Id, JSONRPCMethodNotFound, "Method not found")
+	}
+	args, err := method.args(req.Params)
+	if err != nil {
+		if isNotification {
+			return nil
+		}
+		return jsonRPCErrorResponse(req.Id, JSONRPCInvalidParams, "Invalid params: "+err.Error())
+	}
+
+	outs := method.fn.Call(append([]reflect.Value{reflect.ValueOf(ctx)}, args...))
+	if isNotification {
+		return nil
+	}
+	if errValue := outs[len(outs)-1]; !errValue.IsNil() {
+		if rpcErr, ok := errValue.Interface().(*JSONRPCError); ok {
+			return &jsonRPCResponse{Version: kJSONRPCVersion, Error: rpcErr, Id: req.Id}
+		}
+		return jsonRPCErrorResponse(req.Id, JSONRPCServerError, errValue.Interface().(error).Error())
+	}
+	var result interface{}
+	if method.hasResult {
+		result = outs[0].Interface()
+	}
+	if result == nil {
+		// the result member is required on success
+		result = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{Version: kJSONRPCVersion, Result: result, Id: req.Id}
+}
+
+// args decodes the positional or named params to the method arguments.
+func (m *jsonRPCMethod) args(params json.RawMessage) ([]reflect.Value, error) {
+	args := make([]reflect.Value, len(m.argTypes))
+	for i, t := range m.argTypes {
+		args[i] = reflect.Zero(t)
+	}
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || string(params) == "null" {
+		return args, nil
+	}
+
+	var rawArgs []json.RawMessage
+	switch params[0] {
+	case '[':
+		if err := json.Unmarshal(params, &rawArgs); err != nil {
+			return nil, err
+		}
+		if len(rawArgs) != len(m.argTypes) {
+			if len(m.argTypes) != 1 {
+				return nil, fmt.Errorf("expected %d params, got %d", len(m.argTypes), len(rawArgs))
+			}
+			rawArgs = []json.RawMessage{params}
+		}
+	case '{':
+		if len(m.argTypes) != 1 {
+			return nil, fmt.Errorf("named params are only supported for the methods with one argument")
+		}
+		rawArgs = []json.RawMessage{params}
+	default:
+		return nil, fmt.Errorf("params should be an array or an object")
+	}
+
+	for i, t := range m.argTypes {
+		isPtr := t.Kind() == reflect.Ptr
+		if isPtr {
+			t = t.Elem()
+		}
+		arg := reflect.New(t)
+		if err := json.Unmarshal(rawArgs[i], arg.Interface()); err != nil {
+			return nil, err
+		}
+		if isPtr {
+			args[i] = arg
+		} else {
+			args[i] = arg.Elem()
+		}
+	}
+	return args, nil
+}
+
+func validJSONRPCId(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	var v interface{}
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+func jsonRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{
+		Version: kJSONRPCVersion,
+		Error:   &JSONRPCError{Code: code, Message: message},
+		Id:      id,
+	}
+}
+
+func writeJSONRPC(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(jsonRPCErrorResponse(nil, JSONRPCInternalError, err.Error()))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type rpcEcho struct {
+	Message string `json:"message"`
+}
+
+type rpcService struct {
+	notified int
+}
+
+func (s *rpcService) Add(ctx context.Context, a int, b int) (int, error) {
+	return a + b, nil
+}
+
+func (s *rpcService) Echo(ctx context.Context, params *rpcEcho) (*rpcEcho, error) {
+	return params, nil
+}
+
+func (s *rpcService) Notify(ctx context.Context) error {
+	s.notified++
+	return nil
+}
+
+func (s *rpcService) Fail(ctx context.Context) error {
+	return errors.New("failed")
+}
+
+func (s *rpcService) Custom(ctx context.Context) error {
+	return &JSONRPCError{Code: 42, Message: "custom"}
+}
+
+func (s *rpcService) NotRPC(a int) int {
+	return a
+}
+
+func TestJSONRPC(t *testing.T) {
+	service := &rpcService{}
+	srv := New(context.Background(), false)
+	srv.JSONRPC("/rpc", "RPC", service)
+
+	cases := []struct {
+		body     string
+		status   int
+		expected string
+	}{
+		{`{"jsonrpc": "2.0", "method": "Add", "params": [1, 2], "id": 1}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "Echo", "params": {"message": "hi"}, "id": "a"}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":{"message":"hi"},"id":"a"}`},
+		{`{"jsonrpc": "2.0", "method": "Notify", "id": null}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":null,"id":null}`},
+		{`{"jsonrpc": "2.0", "method": "Notify"}`, http.StatusNoContent, ``},
+		{`{"jsonrpc": "2.0", "method": "Fail", "id": 2}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"failed"},"id":2}`},
+		{`{"jsonrpc": "2.0", "method": "Custom", "id": 3}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":42,"message":"custom"},"id":3}`},
+		{`{"jsonrpc": "2.0", "method": "NotRPC", "id": 4}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":4}`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": [1], "id": 5}`, http.StatusOK, `-32602`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": "x", "id": 6}`, http.StatusOK, `-32602`},
+		{`{"jsonrpc": "2.0", "method": 1, "params": "bar", "baz]`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":1,"params":"bar"}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`1`, http.StatusOK, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{``, http.StatusOK, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc": "1.0", "method": "Add", "id": 7}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[]`, http.StatusOK, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[1, {"jsonrpc": "2.0", "method": "Add", "params": [2, 3], "id": 8}, {"jsonrpc": "2.0", "method": "Notify"}]`,
+			http.StatusOK,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","result":5,"id":8}]`},
+		{`[{"jsonrpc": "2.0", "method": "Notify"}, {"jsonrpc": "2.0", "method": "Notify"}]`, http.StatusNoContent, ``},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/rpc", strings.NewReader(c.body))
+		srv.router.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("Wrong status for %s, expected=%d, got=%d", c.body, c.status, w.Code)
+			continue
+		}
+		body := strings.TrimSpace(w.Body.String())
+		if !strings.HasPrefix(c.expected, "-") {
+			if body != c.expected {
+				t.Errorf("Wrong response for %s, expected=%s, got=%s", c.body, c.expected, body)
+			}
+			continue
+		}
+		var res jsonRPCResponse
+		if err := json.Unmarshal([]byte(body), &res); err != nil || res.Error == nil || res.Error.Code != JSONRPCInvalidParams {
+			t.Errorf("Should respond invalid params for %s, got=%s", c.body, body)
+		}
+	}
+	if service.notified != 5 {
+		t.Errorf("Wrong notified count, expected=5, got=%d", service.notified)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/rpc", strings.NewReader(strings.Repeat(" ", kBodyMaxSize+1)))
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized request should be rejected with 413, got=%d", w.Code)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mijia/sweb/log"
+)
+
+var (
+	// ErrInvalidToken is returned if the JWT is malformed or the signature is not valid.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned if the JWT is expired or not valid yet.
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// JWTOptions defines the options of the JWTVerifier.
+type JWTOptions struct {
+	// The secret for HS256
+	Secret []byte
+	// The public keys for RS256 and ES256 by the kid, e.g. from LoadJWKS
+	Keys map[string]crypto.PublicKey
+	// The required issuer "iss"
+	Issuer string
+	// The required audience "aud"
+	Audience string
+	// The clock skew allowed for "exp" and "nbf"
+	Leeway time.Duration
+	// The claim of the roles, default is "roles"
+	RolesClaim string
+	// The tokens without "exp" are rejected unless allowed, since they would never expire
+	AllowNoExpiry bool
+}
+
+// JWTVerifier verifies the JWTs signed by HS256, RS256 or ES256. The algorithm must match the key type, so that a
+// token signed by HS256 with the public key would be rejected.
+type JWTVerifier struct {
+	opt JWTOptions
+}
+
+// NewJWTVerifier returns a new JWTVerifier.
+func NewJWTVerifier(opt JWTOptions) *JWTVerifier {
+	if opt.RolesClaim == "" {
+		opt.RolesClaim = "roles"
+	}
+	return &JWTVerifier{opt}
+}
+
+// Verify verifies the token and returns the claims.
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(v.opt.Leeway)) {
+			return nil, ErrTokenExpired
+		}
+	} else if _, found := claims["exp"]; found || !v.opt.AllowNoExpiry {
+		log.Debugf("[JWTVerifier] The token has no valid expiration")
+		return nil, ErrInvalidToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.opt.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, ErrTokenExpired
+	}
+	if v.opt.Issuer != "" && claims["iss"] != v.opt.Issuer {
+		log.Debugf("[JWTVerifier] The token issuer %v mismatched", claims["iss"])
+		return nil, ErrInvalidToken
+	}
+	if v.opt.Audience != "" && !jwtAudience(claims["aud"], v.opt.Audience) {
+		log.Debugf("[JWTVerifier] The token audience %v mismatched", claims["aud"])
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Principal returns the principal of the verified claims.
+func (v *JWTVerifier) Principal(claims map[string]interface{}) *Principal {
+	principal := &Principal{Scheme: "jwt", Claims: claims}
+	principal.Subject, _ = claims["sub"].(string)
+	switch roles := claims[v.opt.RolesClaim].(type) {
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, s)
+			}
+		}
+	case string:
+		principal.Roles = strings.Fields(roles)
+	}
+	return principal
+}
+
+func (v *JWTVerifier) verifySignature(alg, kid, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "HS256":
+		if len(v.opt.Secret) == 0 {
+			return ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, v.opt.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalidToken
+		}
+	case "RS256":
+		key, ok := v.key(kid).(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+			return ErrInvalidToken
+		}
+	case "ES256":
+		key, ok := v.key(kid).(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() || len(signature) != 64 {
+			return ErrInvalidToken
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return ErrInvalidToken
+		}
+	default:
+		log.Debugf("[JWTVerifier] The token alg %q is not supported", alg)
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// key returns the public key by the kid, or the only key if the token has no kid.
+func (v *JWTVerifier) key(kid string) crypto.PublicKey {
+	if key, ok := v.opt.Keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(v.opt.Keys) == 1 {
+		for _, key := range v.opt.Keys {
+			return key
+		}
+	}
+	return nil
+}
+
+type jwtAuth struct {
+	verifier *JWTVerifier
+}
+
+// JWTAuth returns the authenticator for the JWT bearer tokens, the opaque tokens are left for the other ones.
+func JWTAuth(verifier *JWTVerifier) Authenticator {
+	return &jwtAuth{verifier}
+}
+
+func (a *jwtAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	claims, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return a.verifier.Principal(claims), nil
+}
+
+func (a *jwtAuth) Challenge() string {
+	return "Bearer"
+}
+
+// LoadJWKS loads the RSA and P-256 EC public keys by the kid from the JWK Set file.
+func LoadJWKS(file string) (map[string]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				return nil, fmt.Errorf("invalid RSA key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				return nil, fmt.Errorf("unsupported curve %q of key %q", jwk.Crv, jwk.Kid)
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if errX != nil || errY != nil || !key.Curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("invalid EC key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func jwtAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
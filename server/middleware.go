@@ -77,9 +77,20 @@ func (m *StatWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http
 	newCtx := next(ctx, w, r)
 	res := w.(ResponseWriter)
 	urlPath := r.URL.Path
+	size := fmt.Sprintf("%d", res.Size())
+	if sizer, ok := res.(interface {
+		RawSize() int
+	}); ok && sizer.RawSize() != res.Size() {
+		size = fmt.Sprintf("%d/%d", res.Size(), sizer.RawSize())
+	}
+	// the inner ProxyWare may resolve the client ip into the returned context
+	ip := ClientIP(newCtx)
+	if ip == "" {
+		ip = remoteHost(r)
+	}
 	if res.Status() >= 400 {
-		log.Warnf("Request %q %q, status=%v, size=%d, duration=%v",
-			r.Method, r.URL.Path, res.Status(), res.Size(), time.Since(start))
+		log.Warnf("Request %q %q, status=%v, size=%s, duration=%v, ip=%s",
+			r.Method, r.URL.Path, res.Status(), size, time.Since(start), ip)
 	} else {
 		ignored := false
 		for _, prefix := range m.ignoredPrefixes {
@@ -89,8 +100,8 @@ func (m *StatWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http
 			}
 		}
 		if !ignored {
-			log.Infof("Request %q %q, status=%v, size=%d, duration=%v",
-				r.Method, r.URL.Path, res.Status(), res.Size(), time.Since(start))
+			log.Infof("Request %q %q, status=%v, size=%s, duration=%v, ip=%s",
+				r.Method, r.URL.Path, res.Status(), size, time.Since(start), ip)
 		}
 	}
 	return newCtx
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	kOpenAPIVersion   = "3.0.3"
+	kOpenAPIUpdateEnv = "SWEB_UPDATE_OPENAPI"
+)
+
+// OpenAPI defines the OpenAPI 3 document, only the parts used by sweb are supported.
+type OpenAPI struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       OpenAPIInfo                 `json:"info"`
+	Paths      map[string]*OpenAPIPathItem `json:"paths"`
+	Components *OpenAPIComponents          `json:"components,omitempty"`
+}
+
+// OpenAPIInfo defines the info object of the document.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents keeps the reusable schemas referenced by "#/components/schemas/{name}".
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+}
+
+// OpenAPIPathItem defines the operations on a path, the parameters would be shared by all the operations.
+type OpenAPIPathItem struct {
+	Parameters []*OpenAPIParameter `json:"parameters,omitempty"`
+	Get        *OpenAPIOperation   `json:"get,omitempty"`
+	Put        *OpenAPIOperation   `json:"put,omitempty"`
+	Post       *OpenAPIOperation   `json:"post,omitempty"`
+	Delete     *OpenAPIOperation   `json:"delete,omitempty"`
+	Options    *OpenAPIOperation   `json:"options,omitempty"`
+	Head       *OpenAPIOperation   `json:"head,omitempty"`
+	Patch      *OpenAPIOperation   `json:"patch,omitempty"`
+}
+
+// Operation returns the operation for the http method, nil if not defined.
+func (item *OpenAPIPathItem) Operation(method string) *OpenAPIOperation {
+	if op := item.operation(method); op != nil {
+		return *op
+	}
+	return nil
+}
+
+func (item *OpenAPIPathItem) operation(method string) **OpenAPIOperation {
+	switch method {
+	case "GET":
+		return &item.Get
+	case "PUT":
+		return &item.Put
+	case "POST":
+		return &item.Post
+	case "DELETE":
+		return &item.Delete
+	case "OPTIONS":
+		return &item.Options
+	case "HEAD":
+		return &item.Head
+	case "PATCH":
+		return &item.Patch
+	}
+	return nil
+}
+
+// OpenAPIOperation defines an operation on a path, the operation id would be the route name.
+type OpenAPIOperation struct {
+	OperationId string                      `json:"operationId,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter defines a path, query, header or cookie parameter.
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema,omitempty"`
+}
+
+// OpenAPIRequestBody defines the request body keyed by media types.
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse defines a response of the operation.
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType wraps the schema for a media type.
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema,omitempty"`
+}
+
+// OpenAPISchema defines a subset of the json schema used by the OpenAPI 3.
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+
+	// NoAdditionalProperties is set when the document declares "additionalProperties: false"
+	NoAdditionalProperties bool `json:"-"`
+}
+
+// UnmarshalJSON supports the boolean form of the "additionalProperties".
+func (schema *OpenAPISchema) UnmarshalJSON(data []byte) error {
+	type plainSchema OpenAPISchema
+	var raw struct {
+		plainSchema
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*schema = OpenAPISchema(raw.plainSchema)
+	switch additional := strings.TrimSpace(string(raw.AdditionalProperties)); additional {
+	case "", "true":
+	case "false":
+		schema.NoAdditionalProperties = true
+	default:
+		schema.AdditionalProperties = &OpenAPISchema{}
+		return json.Unmarshal(raw.AdditionalProperties, schema.AdditionalProperties)
+	}
+	return nil
+}
+
+// LoadOpenAPI loads the OpenAPI 3 json document from the file.
+func LoadOpenAPI(jsonFile string) (*OpenAPI, error) {
+	data, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		return nil, err
+	}
+	doc := &OpenAPI{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Operation finds the operation by the http method and the httprouter path pattern like "/users/:id".
+func (doc *OpenAPI) Operation(method string, path string) (*OpenAPIOperation, *OpenAPIPathItem) {
+	apiPath, _ := openAPIPath(path)
+	if item, ok := doc.Paths[apiPath]; ok {
+		return item.Operation(method), item
+	}
+	return nil, nil
+}
+
+// Schema resolves the schema reference like "#/components/schemas/User", the schema would be returned as is if
+// it is not a reference.
+func (doc *OpenAPI) Schema(schema *OpenAPISchema) *OpenAPISchema {
+	for i := 0; schema != nil && schema.Ref != "" && i < 32; i++ {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if doc.Components == nil || name == schema.Ref {
+			return nil
+		}
+		schema = doc.Components.Schemas[name]
+	}
+	return schema
+}
+
+// DescribeRoute attaches the request and response types to the named route for the OpenAPI document, e.g. the json
+// body the handler binds and the data it responds. Either one can be nil. The types of the routes registered by the
+// HandleJson are described already.
+func (s *Server) DescribeRoute(name string, request interface{}, response interface{}) {
+	found := false
+	for _, route := range s.routes {
+		if route.name == name {
+			route.request = reflect.TypeOf(request)
+			route.response = reflect.TypeOf(response)
+			found = true
+		}
+	}
+	if !found {
+		log.Warnf("Server describe route failed, cannot find named routes %q", name)
+	}
+}
+
+// OpenAPI generates the OpenAPI 3 document from the server's route table.
+func (s *Server) OpenAPI(title string, version string) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: kOpenAPIVersion,
+		Info:    OpenAPIInfo{title, version},
+		Paths:   make(map[string]*OpenAPIPathItem),
+	}
+	schemas := newSchemaRegistry()
+	for _, route := range s.routes {
+		if route.name == s.openAPIRoute {
+			continue
+		}
+		apiPath, params := openAPIPath(route.path)
+		op := &OpenAPIOperation{
+			OperationId: route.name,
+			Parameters:  params,
+			Responses:   make(map[string]*OpenAPIResponse),
+		}
+		if route.request != nil && (route.method == "POST" || route.method == "PUT" || route.method == "PATCH") {
+			op.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  jsonMediaType(typeSchema(route.request, schemas)),
+			}
+		}
+		if route.response != nil {
+			op.Responses["200"] = &OpenAPIResponse{
+				Description: http.StatusText(http.StatusOK),
+				Content:     jsonMediaType(typeSchema(route.response, schemas)),
+			}
+		} else {
+			op.Responses["default"] = &OpenAPIResponse{Description: "Response"}
+		}
+		item, ok := doc.Paths[apiPath]
+		if !ok {
+			item = &OpenAPIPathItem{}
+			doc.Paths[apiPath] = item
+		}
+		if pOp := item.operation(route.method); pOp != nil {
+			*pOp = op
+		}
+	}
+	if components := schemas.components(); len(components) > 0 {
+		doc.Components = &OpenAPIComponents{components}
+	}
+	return doc
+}
+
+// EnableOpenAPI serves the generated OpenAPI document at the path with the given routing name, the route itself
+// would not be listed in the document.
+func (s *Server) EnableOpenAPI(path, name, title, version string) {
+	s.openAPIRoute = name
+	s.Get(path, name, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		data, err := json.MarshalIndent(s.OpenAPI(title, version), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return ctx
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(data, '\n'))
+		return ctx
+	})
+}
+
+// CheckOpenAPISpec is a test helper which fails the test when the committed spec file drifts from the document
+// generated by the server. Run the tests with SWEB_UPDATE_OPENAPI=1 to rewrite the spec file.
+func CheckOpenAPISpec(t interface {
+	Errorf(format string, args ...interface{})
+}, s *Server, specFile, title, version string) {
+	data, err := json.MarshalIndent(s.OpenAPI(title, version), "", "  ")
+	if err != nil {
+		t.Errorf("Cannot marshal the OpenAPI document, %s", err)
+		return
+	}
+	data = append(data, '\n')
+	if os.Getenv(kOpenAPIUpdateEnv) != "" {
+		if err := ioutil.WriteFile(specFile, data, 0644); err != nil {
+			t.Errorf("Cannot update the OpenAPI spec %q, %s", specFile, err)
+		}
+		return
+	}
+	committed, err := ioutil.ReadFile(specFile)
+	if err != nil {
+		t.Errorf("Cannot read the OpenAPI spec %q, %s", specFile, err)
+		return
+	}
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(data)) {
+		t.Errorf("The OpenAPI spec %q drifts from the routes, run with %s=1 to update it", specFile, kOpenAPIUpdateEnv)
+	}
+}
+
+// openAPIPath converts the httprouter path like "/users/:id" to "/users/{id}" with the path parameters.
+func openAPIPath(path string) (string, []*OpenAPIParameter) {
+	var params []*OpenAPIParameter
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if len(part) > 1 && (part[0] == ':' || part[0] == '*') {
+			params = append(params, &OpenAPIParameter{
+				Name:     part[1:],
+				In:       "path",
+				Required: true,
+				Schema:   &OpenAPISchema{Type: "string"},
+			})
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func jsonMediaType(schema *OpenAPISchema) map[string]*OpenAPIMediaType {
+	return map[string]*OpenAPIMediaType{
+		"application/json": &OpenAPIMediaType{schema},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaRegistry keeps the schemas of the named structs by the go types, so the types with the same name from
+// different packages would not overwrite each other.
+type schemaRegistry struct {
+	schemas map[reflect.Type]*OpenAPISchema
+	refs    map[reflect.Type][]*OpenAPISchema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		schemas: make(map[reflect.Type]*OpenAPISchema),
+		refs:    make(map[reflect.Type][]*OpenAPISchema),
+	}
+}
+
+// components names the schemas and resolves the references, the short type name is used only if it's unique,
+// otherwise the name is qualified by the package path, e.g. "github.com.mijia.app.models.User".
+func (reg *schemaRegistry) components() map[string]*OpenAPISchema {
+	counts := make(map[string]int)
+	for t := range reg.schemas {
+		counts[t.Name()]++
+	}
+	components := make(map[string]*OpenAPISchema)
+	for t, schema := range reg.schemas {
+		name := t.Name()
+		if counts[name] > 1 {
+			name = strings.Replace(t.PkgPath(), "/", ".", -1) + "." + name
+		}
+		components[name] = schema
+		for _, ref := range reg.refs[t] {
+			ref.Ref = "#/components/schemas/" + name
+		}
+	}
+	return components
+}
+
+// typeSchema generates the schema for the go type as the encoding/json would marshal it, named structs would be
+// kept in the schemas as components and referenced.
+func typeSchema(t reflect.Type, schemas *schemaRegistry) *OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint64:
+		// the values may exceed the int64
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas.schemas[t]; !ok {
+			// placeholder for the recursive types
+			schemas.schemas[t] = &OpenAPISchema{}
+			schemas.schemas[t] = structSchema(t, schemas)
+		}
+		// the ref would be resolved when all the schemas are known
+		ref := &OpenAPISchema{}
+		schemas.refs[t] = append(schemas.refs[t], ref)
+		return ref
+	}
+	return &OpenAPISchema{}
+}
+
+func structSchema(t reflect.Type, schemas *schemaRegistry) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				sub := structSchema(embedded, schemas)
+				for subName, subSchema := range sub.Properties {
+					if _, ok := schema.Properties[subName]; !ok {
+						schema.Properties[subName] = subSchema
+					}
+				}
+				schema.Required = append(schema.Required, sub.Required...)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = typeSchema(field.Type, schemas)
+		omitEmpty := false
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				omitEmpty = true
+			}
+		}
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type oaUser struct {
+	Id      int64     `json:"id"`
+	Age     uint32    `json:"age,omitempty"`
+	Name    string    `json:"name"`
+	Friends []*oaUser `json:"friends,omitempty"`
+}
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Get("/users/:id", "GetUser", DummyHandle)
+	srv.Post("/users", "CreateUser", DummyHandle)
+	srv.Get("/files/*filepath", "Files", DummyHandle)
+	srv.DescribeRoute("GetUser", nil, oaUser{})
+	srv.DescribeRoute("CreateUser", oaUser{}, oaUser{})
+	srv.EnableOpenAPI("/openapi.json", "OpenAPI", "Test", "1.0")
+
+	doc := srv.OpenAPI("Test", "1.0")
+	if _, ok := doc.Paths["/openapi.json"]; ok {
+		t.Errorf("The OpenAPI route should not be listed")
+	}
+	op := doc.Paths["/users/{id}"].Get
+	if op == nil || op.OperationId != "GetUser" {
+		t.Fatalf("Cannot find the GetUser operation")
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "id" || op.Parameters[0].In != "path" {
+		t.Errorf("Wrong path parameters for GetUser, %+v", op.Parameters)
+	}
+	if ref := op.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/oaUser" {
+		t.Errorf("Wrong response schema ref, got=%q", ref)
+	}
+	if op := doc.Paths["/users"].Post; op == nil || op.RequestBody == nil {
+		t.Errorf("Should have the request body for CreateUser")
+	}
+	if op := doc.Paths["/files/{filepath}"].Get; op == nil || op.Responses["default"] == nil {
+		t.Errorf("Should have the default response for Files")
+	}
+	user := doc.Components.Schemas["oaUser"]
+	if user == nil || user.Properties["friends"].Items.Ref != "#/components/schemas/oaUser" {
+		t.Fatalf("Wrong recursive schema for oaUser")
+	}
+	if len(user.Required) != 2 || user.Required[0] != "id" || user.Required[1] != "name" {
+		t.Errorf("Wrong required properties, %v", user.Required)
+	}
+	if user.Properties["age"].Format != "int64" {
+		t.Errorf("The uint32 should be int64, got=%q", user.Properties["age"].Format)
+	}
+
+	dir, _ := ioutil.TempDir("", "sweb_openapi")
+	defer os.RemoveAll(dir)
+	specFile := filepath.Join(dir, "openapi.json")
+	os.Setenv(kOpenAPIUpdateEnv, "1")
+	CheckOpenAPISpec(t, srv, specFile, "Test", "1.0")
+	os.Unsetenv(kOpenAPIUpdateEnv)
+	CheckOpenAPISpec(t, srv, specFile, "Test", "1.0")
+
+	srv.Delete("/users/:id", "DeleteUser", DummyHandle)
+	ft := &fakeT{}
+	CheckOpenAPISpec(ft, srv, specFile, "Test", "1.0")
+	if len(ft.errors) != 1 {
+		t.Errorf("Should report the drift of the spec")
+	}
+}
+
+// Cookie has the same name as the http.Cookie
+type Cookie struct {
+	Flavor string `json:"flavor"`
+}
+
+func TestHandleJson(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.HandleJson("POST", "/users", "CreateUser", func(ctx context.Context, r *http.Request, user *oaUser) (int, *oaUser) {
+		user.Id = 1
+		return http.StatusCreated, user
+	})
+	srv.HandleJson("GET", "/count", "Count", func(ctx context.Context, r *http.Request) (int, int) {
+		return http.StatusOK, 42
+	})
+
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		expected           string
+	}{
+		{"POST", "/users", `{"name": "mijia"}`, http.StatusCreated, `{"id":1,"name":"mijia"}`},
+		{"POST", "/users", `{"name": 1}`, http.StatusBadRequest, ""},
+		{"GET", "/count", "", http.StatusOK, "42"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		srv.ServeHTTP(w, r)
+		body := strings.Join(strings.Fields(w.Body.String()), "")
+		if w.Code != c.status || (c.expected != "" && body != c.expected) {
+			t.Errorf("%s %s should get %d %s, got %d %s", c.method, c.path, c.status, c.expected, w.Code, body)
+		}
+	}
+
+	doc := srv.OpenAPI("Test", "1.0")
+	op := doc.Paths["/users"].Post
+	if op.RequestBody == nil || op.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/oaUser" {
+		t.Errorf("Request schema should be derived from the typed handler")
+	}
+	if schema := doc.Paths["/count"].Get.Responses["200"].Content["application/json"].Schema; schema.Format != "int64" {
+		t.Errorf("Response schema should be derived from the typed handler, %+v", schema)
+	}
+}
+
+func TestOpenAPISchemaNames(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Get("/cookies", "Cookies", DummyHandle)
+	srv.DescribeRoute("Cookies", nil, struct {
+		Ours   Cookie      `json:"ours"`
+		Theirs http.Cookie `json:"theirs"`
+		User   oaUser      `json:"user"`
+	}{})
+	doc := srv.OpenAPI("Test", "1.0")
+	schemas := doc.Components.Schemas
+	if schemas["github.com.mijia.sweb.server.Cookie"] == nil || schemas["net.http.Cookie"] == nil ||
+		schemas["oaUser"] == nil || len(schemas) != 3 {
+		t.Fatalf("Same named types should be qualified by the package, %v", schemas)
+	}
+	props := doc.Paths["/cookies"].Get.Responses["200"].Content["application/json"].Schema.Properties
+	if props["theirs"].Ref != "#/components/schemas/net.http.Cookie" || props["user"].Ref != "#/components/schemas/oaUser" {
+		t.Errorf("Schema refs mismatched, %q %q", props["theirs"].Ref, props["user"].Ref)
+	}
+	if schemas["net.http.Cookie"].Properties["Expires"].Format != "date-time" {
+		t.Errorf("Qualified schema should be generated, %+v", schemas["net.http.Cookie"])
+	}
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+// OpenAPIWare is the middleware validating the requests against the operations of an OpenAPI 3 document, the
+// operation is matched by the route pattern. Invalid requests would be responded with problem+json 400.
+type OpenAPIWare struct {
+	doc               *OpenAPI
+	validateResponses bool
+	patterns          map[string]*regexp.Regexp
+	lock              sync.RWMutex
+}
+
+// ServeHTTP implements the Middleware interface, validates the path params, query, headers and the json body.
+func (m *OpenAPIWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	routePath := RoutePath(ctx)
+	if routePath == "" {
+		return next(ctx, w, r)
+	}
+	op, item := m.doc.Operation(r.Method, routePath)
+	if op == nil {
+		return next(ctx, w, r)
+	}
+
+	var errs []string
+	for _, param := range m.parameters(item, op) {
+		errs = append(errs, m.validateParam(ctx, r, param)...)
+	}
+	if op.RequestBody != nil {
+		status, bodyErrs := m.validateBody(r, op.RequestBody)
+		if status == http.StatusUnsupportedMediaType || status == http.StatusRequestEntityTooLarge {
+			WriteProblem(w, status, bodyErrs[0])
+			return ctx
+		}
+		errs = append(errs, bodyErrs...)
+	}
+	if len(errs) > 0 {
+		WriteProblem(w, http.StatusBadRequest, "Request does not match the API operation "+op.OperationId, errs...)
+		return ctx
+	}
+
+	if !m.validateResponses {
+		return next(ctx, w, r)
+	}
+	recorder := &recordResponseWriter{ResponseWriter: w.(ResponseWriter)}
+	newCtx := next(ctx, recorder, r)
+	for _, err := range m.validateResponse(op, recorder) {
+		log.Errorf("[OpenAPIWare] Response of %s %q violates the API operation %s: %s",
+			r.Method, r.URL.Path, op.OperationId, err)
+	}
+	return newCtx
+}
+
+// parameters merges the path item parameters and operation parameters, the latter one overrides.
+func (m *OpenAPIWare) parameters(item *OpenAPIPathItem, op *OpenAPIOperation) []*OpenAPIParameter {
+	params := make([]*OpenAPIParameter, 0, len(item.Parameters)+len(op.Parameters))
+	overridden := make(map[string]bool)
+	for _, param := range op.Parameters {
+		overridden[param.In+":"+param.Name] = true
+		params = append(params, param)
+	}
+	for _, param := range item.Parameters {
+		if !overridden[param.In+":"+param.Name] {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+func (m *OpenAPIWare) validateParam(ctx context.Context, r *http.Request, param *OpenAPIParameter) []string {
+	var values []string
+	switch param.In {
+	case "path":
+		if value := Params(ctx, param.Name); value != "" {
+			values = []string{value}
+		}
+	case "query":
+		values = r.URL.Query()[param.Name]
+	case "header":
+		values = r.Header[http.CanonicalHeaderKey(param.Name)]
+	case "cookie":
+		if cookie, err := r.Cookie(param.Name); err == nil {
+			values = []string{cookie.Value}
+		}
+	}
+	name := fmt.Sprintf("%s parameter %q", param.In, param.Name)
+	if len(values) == 0 {
+		if param.Required || param.In == "path" {
+			return []string{name + " is required"}
+		}
+		return nil
+	}
+	schema := m.doc.Schema(param.Schema)
+	if schema == nil {
+		return nil
+	}
+	if schema.Type == "array" {
+		if len(values) == 1 && param.In != "query" {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		for i, value := range values {
+			items[i] = m.parseParam(m.doc.Schema(schema.Items), value)
+		}
+		return m.validateValue(schema, items, name)
+	}
+	return m.validateValue(schema, m.parseParam(schema, values[0]), name)
+}
+
+// parseParam converts the string parameter to the json value by the schema type.
+func (m *OpenAPIWare) parseParam(schema *OpenAPISchema, value string) interface{} {
+	if schema == nil {
+		return value
+	}
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// validateBody reads the body within the limits of the BodyLimitWare if registered before, otherwise the default
+// limit of the body size.
+func (m *OpenAPIWare) validateBody(r *http.Request, body *OpenAPIRequestBody) (int, []string) {
+	reader, limit := io.Reader(r.Body), int64(kBodyMaxSize)
+	if _, ok := r.Body.(*limitedBody); ok {
+		limit = 0
+	} else {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err == ErrBodyTooLarge || (limit > 0 && int64(len(data)) > limit) {
+		return http.StatusRequestEntityTooLarge, []string{"Request body exceeds the size limit"}
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return http.StatusBadRequest, []string{"cannot read the request body, " + err.Error()}
+	}
+	if len(data) == 0 {
+		if body.Required {
+			return http.StatusBadRequest, []string{"request body is required"}
+		}
+		return http.StatusOK, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	content, ok := body.Content[mediaType]
+	if !ok {
+		content, ok = body.Content["*/*"]
+	}
+	if !ok {
+		return http.StatusUnsupportedMediaType, []string{fmt.Sprintf("Content type %q is not supported", mediaType)}
+	}
+	if content.Schema == nil || !isJsonMediaType(mediaType) {
+		return http.StatusOK, nil
+	}
+	value, err := decodeJsonNumber(data)
+	if err != nil {
+		return http.StatusBadRequest, []string{"cannot decode the json body, " + err.Error()}
+	}
+	return http.StatusOK, m.validateValue(content.Schema, value, "body")
+}
+
+func (m *OpenAPIWare) validateResponse(op *OpenAPIOperation, recorder *recordResponseWriter) []string {
+	status := recorder.Status()
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses[fmt.Sprintf("%dXX", status/100)]
+	}
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []string{fmt.Sprintf("status %d is not declared", status)}
+	}
+	if len(response.Content) == 0 || status == http.StatusNoContent || recorder.body.Len() == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return []string{fmt.Sprintf("content type %q is not declared", mediaType)}
+	}
+	if content.Schema == nil || !isJsonMediaType(mediaType) {
+		return nil
+	}
+	value, err := decodeJsonNumber(recorder.body.Bytes())
+	if err != nil {
+		return []string{"cannot decode the json body, " + err.Error()}
+	}
+	return m.validateValue(content.Schema, value, "body")
+}
+
+// validateValue validates the decoded json value against the schema, returns all the violations.
+func (m *OpenAPIWare) validateValue(schema *OpenAPISchema, value interface{}, name string) []string {
+	schema = m.doc.Schema(schema)
+	if schema == nil {
+		return nil
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return []string{name + " should not be null"}
+	}
+
+	var errs []string
+	if len(schema.Enum) > 0 {
+		matched := false
+		for _, option := range schema.Enum {
+			if jsonEqual(option, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%s should be one of %v", name, schema.Enum))
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, name+" should be an object")
+		}
+		for _, required := range schema.Required {
+			if _, ok := object[required]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s is required", name, required))
+			}
+		}
+		for _, key := range sortedKeys(object) {
+			subName := name + "." + key
+			if propSchema, ok := schema.Properties[key]; ok {
+				errs = append(errs, m.validateValue(propSchema, object[key], subName)...)
+			} else if schema.AdditionalProperties != nil {
+				errs = append(errs, m.validateValue(schema.AdditionalProperties, object[key], subName)...)
+			} else if schema.NoAdditionalProperties {
+				errs = append(errs, subName+" is not allowed")
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(errs, name+" should be an array")
+		}
+		if schema.MinItems != nil && len(array) < *schema.MinItems {
+			errs = append(errs, fmt.Sprintf("%s should have at least %d items", name, *schema.MinItems))
+		}
+		if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s should have at most %d items", name, *schema.MaxItems))
+		}
+		for i, item := range array {
+			errs = append(errs, m.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", name, i))...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return append(errs, name+" should be a string")
+		}
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			errs = append(errs, fmt.Sprintf("%s should be at least %d characters", name, *schema.MinLength))
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s should be at most %d characters", name, *schema.MaxLength))
+		}
+		if schema.Pattern != "" {
+			if re := m.pattern(schema.Pattern); re != nil && !re.MatchString(str) {
+				errs = append(errs, fmt.Sprintf("%s should match the pattern %q", name, schema.Pattern))
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s should be a %s", name, schema.Type))
+		}
+		f, err := number.Float64()
+		if err != nil || (schema.Type == "integer" && f != float64(int64(f))) {
+			return append(errs, fmt.Sprintf("%s should be a %s", name, schema.Type))
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			errs = append(errs, fmt.Sprintf("%s should be at least %v", name, *schema.Minimum))
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			errs = append(errs, fmt.Sprintf("%s should be at most %v", name, *schema.Maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, name+" should be a boolean")
+		}
+	}
+	return errs
+}
+
+func (m *OpenAPIWare) pattern(pattern string) *regexp.Regexp {
+	m.lock.RLock()
+	re, ok := m.patterns[pattern]
+	m.lock.RUnlock()
+	if ok {
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Warnf("[OpenAPIWare] Invalid pattern %q in the API document, %s", pattern, err)
+	}
+	m.lock.Lock()
+	m.patterns[pattern] = re
+	m.lock.Unlock()
+	return re
+}
+
+// NewOpenAPIWare returns a new OpenAPIWare with the document, if validateResponses is enabled and the server is in
+// debug mode, the json responses would also be validated and the violations would be logged.
+func (s *Server) NewOpenAPIWare(doc *OpenAPI, validateResponses bool) Middleware {
+	return &OpenAPIWare{
+		doc:               doc,
+		validateResponses: validateResponses && s.debug,
+		patterns:          make(map[string]*regexp.Regexp),
+	}
+}
+
+// recordResponseWriter writes through the response and keeps a copy of the body.
+type recordResponseWriter struct {
+	ResponseWriter
+	body bytes.Buffer
+}
+
+func (rw *recordResponseWriter) Write(b []byte) (int, error) {
+	size, err := rw.ResponseWriter.Write(b)
+	rw.body.Write(b[:size])
+	return size, err
+}
+
+func isJsonMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func decodeJsonNumber(data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// jsonEqual compares the values from the document with the decoded json values.
+func jsonEqual(a, b interface{}) bool {
+	normalize := func(v interface{}) interface{} {
+		if number, ok := v.(json.Number); ok {
+			if f, err := number.Float64(); err == nil {
+				return f
+			}
+		}
+		return v
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+const testOpenAPIDoc = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Test", "version": "1.0"},
+  "paths": {
+    "/users/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "put": {
+        "operationId": "PutUser",
+        "parameters": [
+          {"name": "dryRun", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "X-Request-Id", "in": "header", "required": true, "schema": {"type": "string", "minLength": 4}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+        },
+        "responses": {"200": {"description": "OK"}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "User": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "pattern": "^[a-z]+$"},
+          "role": {"type": "string", "enum": ["admin", "user"]},
+          "age": {"type": "integer", "minimum": 0}
+        }
+      }
+    }
+  }
+}`
+
+func TestOpenAPIWare(t *testing.T) {
+	doc := &OpenAPI{}
+	if err := json.Unmarshal([]byte(testOpenAPIDoc), doc); err != nil {
+		t.Fatalf("Cannot load the OpenAPI document, %s", err)
+	}
+	if !doc.Components.Schemas["User"].NoAdditionalProperties {
+		t.Errorf("Should load the boolean additionalProperties")
+	}
+
+	srv := New(context.Background(), false)
+	srv.Middleware(srv.NewOpenAPIWare(doc, false))
+	srv.Put("/users/:id", "PutUser", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.WriteHeader(http.StatusOK)
+		return ctx
+	})
+
+	cases := []struct {
+		path        string
+		header      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"/users/1", "abcd", "application/json", `{"name": "mijia", "role": "admin", "age": 18}`, http.StatusOK},
+		{"/users/1?dryRun=true", "abcd", "application/json; charset=UTF-8", `{"name": "mijia"}`, http.StatusOK},
+		{"/users/x", "abcd", "application/json", `{"name": "mijia"}`, http.StatusBadRequest},
+		{"/users/1?dryRun=maybe", "abcd", "application/json", `{"name": "mijia"}`, http.StatusBadRequest},
+		{"/users/1", "", "application/json", `{"name": "mijia"}`, http.StatusBadRequest},
+		{"/users/1", "abc", "application/json", `{"name": "mijia"}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", ``, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", `{"role": "admin"}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", `{"name": "Mijia"}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", `{"name": "mijia", "role": "root"}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", `{"name": "mijia", "age": 1.5}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "application/json", `{"name": "mijia", "email": "x"}`, http.StatusBadRequest},
+		{"/users/1", "abcd", "text/plain", `mijia`, http.StatusUnsupportedMediaType},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", c.path, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+		if c.header != "" {
+			r.Header.Set("X-Request-Id", c.header)
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("Wrong status for %s %s, expected=%d, got=%d, %s", c.path, c.body, c.status, w.Code, w.Body)
+		}
+		if w.Code != http.StatusOK && w.Header().Get("Content-Type") != kContentProblem {
+			t.Errorf("Should respond with the problem for %s %s", c.path, c.body)
+		}
+	}
+}
+
+func TestOpenAPIWareBodyLimit(t *testing.T) {
+	doc := &OpenAPI{}
+	json.Unmarshal([]byte(testOpenAPIDoc), doc)
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		return ctx
+	}
+	body := `{"name": "mijia", "role": "` + strings.Repeat("x", 2000) + `"}`
+	for _, c := range []struct {
+		limits *BodyLimits
+		size   int
+		status int
+	}{
+		{nil, kBodyMaxSize + 1, http.StatusRequestEntityTooLarge},
+		{&BodyLimits{MaxSize: 1024}, len(body), http.StatusRequestEntityTooLarge},
+	} {
+		srv := New(context.Background(), false)
+		if c.limits != nil {
+			srv.Middleware(NewBodyLimitWare(*c.limits, nil))
+		}
+		srv.Middleware(srv.NewOpenAPIWare(doc, false))
+		srv.Put("/users/:id", "PutUser", handler)
+		w := httptest.NewRecorder()
+		data := body
+		if c.size > len(body) {
+			data = strings.Repeat(" ", c.size-len(body)) + body
+		}
+		r, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(data))
+		r.ContentLength = -1
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Request-Id", "abcd")
+		srv.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("Body of %d bytes should get %d, got %d", c.size, c.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	kContentProblem = "application/problem+json"
+)
+
+// Problem defines the RFC 7807 problem details for the http api error responses.
+type Problem struct {
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	Status   int      `json:"status"`
+	Detail   string   `json:"detail,omitempty"`
+	Instance string   `json:"instance,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// NewProblem returns a new problem with the status text as the title.
+func NewProblem(status int, detail string, errors ...string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: errors,
+	}
+}
+
+// Write writes the problem as "application/problem+json" to http.ResponseWriter.
+func (p *Problem) Write(w http.ResponseWriter) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", kContentProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteProblem is a shortcut to write a problem with the status and detail.
+func WriteProblem(w http.ResponseWriter, status int, detail string, errors ...string) error {
+	return NewProblem(status, detail, errors...).Write(w)
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kClientKey = "inter_ctx_key_client"
+)
+
+// ProxyOptions defines the options of the ProxyWare.
+type ProxyOptions struct {
+	// The CIDRs or IPs of the trusted proxies, e.g. "10.0.0.0/8" and "127.0.0.1"
+	TrustedProxies []string
+}
+
+type clientInfo struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+type forwardedHop struct {
+	ip    string
+	proto string
+	host  string
+}
+
+// ProxyWare resolves the real client IP, scheme and host of the requests from the trusted proxies, by the RFC 7239
+// Forwarded, X-Forwarded-For or X-Real-IP, and the X-Forwarded-Proto and X-Forwarded-Host. The forwarded hops are
+// walked from the nearest one, the first untrusted address is the client. The headers of the requests from the
+// untrusted peers are ignored, so it should be the first middleware to make the client ip available to the others.
+//
+//	ware, err := server.NewProxyWare(server.ProxyOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+//	srv.Middleware(ware)
+//
+//	ip := server.ClientIP(ctx)
+type ProxyWare struct {
+	trusted []*net.IPNet
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *ProxyWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	client := &clientInfo{ip: remoteHost(r), scheme: "http", host: r.Host}
+	if r.TLS != nil {
+		client.scheme = "https"
+	}
+	if m.isTrusted(client.ip) {
+		hop := forwardedHop{
+			proto: forwardedProto(lastHeaderValue(r, "X-Forwarded-Proto")),
+			host:  lastHeaderValue(r, "X-Forwarded-Host"),
+		}
+		if hops := forwardedHops(r); len(hops) > 0 {
+			// the Forwarded proto and host of the client hop take precedence
+			clientHop := m.clientHop(append(hops, forwardedHop{ip: client.ip}))
+			hop.ip = clientHop.ip
+			if clientHop.proto != "" {
+				hop.proto = clientHop.proto
+			}
+			if clientHop.host != "" {
+				hop.host = clientHop.host
+			}
+		}
+		if hop.ip != "" {
+			client.ip = hop.ip
+		}
+		if hop.proto != "" {
+			client.scheme = hop.proto
+		}
+		if hop.host != "" {
+			client.host = hop.host
+		}
+	}
+	return next(context.WithValue(ctx, kClientKey, client), w, r)
+}
+
+// NewProxyWare returns a new ProxyWare, or the error if the trusted proxies are not valid.
+func NewProxyWare(opt ProxyOptions) (Middleware, error) {
+	m := &ProxyWare{}
+	for _, proxy := range opt.TrustedProxies {
+		cidr := proxy
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, %s", proxy, err)
+		}
+		m.trusted = append(m.trusted, ipNet)
+	}
+	return m, nil
+}
+
+func (m *ProxyWare) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range m.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientHop walks the hops from the nearest one, returns the first untrusted one. The obfuscated or unknown address
+// stops the walk at the proxy reporting it.
+func (m *ProxyWare) clientHop(hops []forwardedHop) forwardedHop {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i].ip) == nil {
+			return hops[i+1]
+		}
+		if !m.isTrusted(hops[i].ip) {
+			return hops[i]
+		}
+	}
+	return hops[0]
+}
+
+// forwardedHops parses the client and proxy addresses from the Forwarded, X-Forwarded-For or X-Real-IP headers.
+func forwardedHops(r *http.Request) []forwardedHop {
+	hops := []forwardedHop{}
+	if values := headerValues(r.Header, "Forwarded"); len(values) > 0 {
+		for _, element := range values {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(element, ";") {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[:i])) {
+				case "for":
+					hop.ip = forwardedIP(value)
+				case "proto":
+					hop.proto = forwardedProto(value)
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	if values := headerValues(r.Header, "X-Forwarded-For"); len(values) > 0 {
+		for _, value := range values {
+			hops = append(hops, forwardedHop{ip: forwardedIP(value)})
+		}
+		return hops
+	}
+	if value := strings.TrimSpace(r.Header.Get("X-Real-IP")); value != "" {
+		hops = append(hops, forwardedHop{ip: forwardedIP(value)})
+	}
+	return hops
+}
+
+// forwardedIP strips the port and the brackets of the IPv6 address, e.g. "[2001:db8::1]:4711".
+func forwardedIP(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+}
+
+// forwardedProto only accepts the "http" and "https", other values are ignored.
+func forwardedProto(value string) string {
+	if proto := strings.ToLower(strings.TrimSpace(value)); proto == "http" || proto == "https" {
+		return proto
+	}
+	return ""
+}
+
+// lastHeaderValue returns the value appended by the nearest proxy.
+func lastHeaderValue(r *http.Request, name string) string {
+	if values := headerValues(r.Header, name); len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the real client ip resolved by the ProxyWare, empty if the ProxyWare is not registered.
+func ClientIP(ctx context.Context) string {
+	if client, ok := ctx.Value(kClientKey).(*clientInfo); ok {
+		return client.ip
+	}
+	return ""
+}
+
+// ClientScheme returns the scheme the client requested, i.e. "http" or "https", resolved by the ProxyWare.
+func ClientScheme(ctx context.Context) string {
+	if client, ok := ctx.Value(kClientKey).(*clientInfo); ok {
+		return client.scheme
+	}
+	return ""
+}
+
+// ClientHost returns the host the client requested resolved by the ProxyWare.
+func ClientHost(ctx context.Context) string {
+	if client, ok := ctx.Value(kClientKey).(*clientInfo); ok {
+		return client.host
+	}
+	return ""
+}
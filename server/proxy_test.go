@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestProxyWare(t *testing.T) {
+	if _, err := NewProxyWare(ProxyOptions{TrustedProxies: []string{"10.0.0.0/33"}}); err == nil {
+		t.Errorf("Invalid CIDR should be rejected")
+	}
+	ware, err := NewProxyWare(ProxyOptions{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(context.Background(), false)
+	srv.Middleware(ware)
+	srv.Get("/ip", "IP", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte(ClientScheme(ctx) + "://" + ClientHost(ctx) + " " + ClientIP(ctx) + " " + RateLimitByIP(ctx, r)))
+		return ctx
+	})
+
+	for _, c := range []struct {
+		remote  string
+		headers map[string]string
+		tls     bool
+		expects string
+	}{
+		{"203.0.113.9:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, false,
+			"http://a.com 203.0.113.9 203.0.113.9"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 5.6.7.8, 192.168.1.1", "X-Forwarded-Proto": "https"}, false,
+			"https://a.com 5.6.7.8 5.6.7.8"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.1.1.1", "X-Forwarded-Host": "b.com"}, true,
+			"https://b.com 10.1.1.1 10.1.1.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Real-IP": "1.2.3.4"}, false,
+			"http://a.com 1.2.3.4 1.2.3.4"},
+		{"[2001:db8::1]:1234", map[string]string{
+			"Forwarded":         `for="[2001:db8:cafe::17]:4711";proto=https;host=c.com, for=10.0.0.2`,
+			"X-Forwarded-Proto": "http",
+		}, false, "https://c.com 2001:db8:cafe::17 2001:db8:cafe::17"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown, for=10.0.0.2"}, false,
+			"http://a.com 10.0.0.2 10.0.0.2"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "javascript"}, true,
+			"https://a.com 10.0.0.1 10.0.0.1"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for=1.2.3.4;proto="ftp"`, "X-Forwarded-Proto": "HTTPS"}, false,
+			"https://a.com 1.2.3.4 1.2.3.4"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://a.com/ip", nil)
+		r.RemoteAddr = c.remote
+		for name, value := range c.headers {
+			r.Header.Set(name, value)
+		}
+		if c.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		srv.ServeHTTP(w, r)
+		if w.Body.String() != c.expects {
+			t.Errorf("Client from %s %v should be %q, got %q", c.remote, c.headers, c.expects, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kRateLimitSweepInterval = time.Minute
+)
+
+// RateLimit defines the token bucket, at most Requests in a burst and refilled at Requests per Period.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult is the result of taking a token from the bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// The duration until the bucket is full again
+	Reset time.Duration
+	// The duration until the next token is available if not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets, can be implemented by the shared storage for multiple instances.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key of the client to be limited, empty key means not limited.
+type RateLimitKeyFunc func(ctx context.Context, r *http.Request) string
+
+// RateLimitByIP limits by the client ip, which is resolved by the ProxyWare if registered.
+func RateLimitByIP(ctx context.Context, r *http.Request) string {
+	if ip := ClientIP(ctx); ip != "" {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+// RateLimitByRoute limits by the route name, i.e. all the clients share the limit.
+func RateLimitByRoute(ctx context.Context, r *http.Request) string {
+	return RouteName(ctx)
+}
+
+// RateLimitByHeader limits by the request header, e.g. the "X-API-Key".
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(ctx context.Context, r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// RateLimitByPrincipal limits by the authenticated principal of the AuthWare, falls back to the client ip.
+func RateLimitByPrincipal(ctx context.Context, r *http.Request) string {
+	if principal, ok := PrincipalFrom(ctx); ok {
+		return principal.String()
+	}
+	return RateLimitByIP(ctx, r)
+}
+
+// RateLimitByContext limits by the value in the context, e.g. the authenticated user, falls back to the fallback
+// key func if the value is missing.
+func RateLimitByContext(key interface{}, fallback RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(ctx context.Context, r *http.Request) string {
+		if value := ctx.Value(key); value != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		if fallback != nil {
+			return fallback(ctx, r)
+		}
+		return ""
+	}
+}
+
+// RateLimitOptions defines the options of the RateLimitWare.
+type RateLimitOptions struct {
+	// The default limit, zero Requests means not limited by default
+	Limit RateLimit
+	// The limits by the route names overriding the default one, negative Requests means never limited for the route
+	Routes map[string]RateLimit
+	// The client key, default is RateLimitByIP
+	Key RateLimitKeyFunc
+	// The token buckets store, default is in memory
+	Store RateLimitStore
+}
+
+// RateLimitWare is the token bucket rate limiting middleware, the routes with their own limits have separate buckets.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set, and the limited requests would get
+// 429 problem with Retry-After.
+type RateLimitWare struct {
+	opt RateLimitOptions
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *RateLimitWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	limit, scope := m.opt.Limit, "*"
+	if routeLimit, ok := m.opt.Routes[RouteName(ctx)]; ok {
+		limit, scope = routeLimit, RouteName(ctx)
+	}
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return next(ctx, w, r)
+	}
+	key := m.opt.Key(ctx, r)
+	if key == "" {
+		return next(ctx, w, r)
+	}
+
+	result, err := m.opt.Store.Take(scope+":"+key, limit)
+	if err != nil {
+		// fail open, the store is not available
+		return next(ctx, w, r)
+	}
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		WriteProblem(w, http.StatusTooManyRequests, "Rate limit exceeded")
+		return ctx
+	}
+	return next(ctx, w, r)
+}
+
+// NewRateLimitWare returns a new RateLimitWare.
+func NewRateLimitWare(opt RateLimitOptions) Middleware {
+	if opt.Key == nil {
+		opt.Key = RateLimitByIP
+	}
+	if opt.Store == nil {
+		opt.Store = NewMemoryRateLimitStore()
+	}
+	return &RateLimitWare{opt}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore is the in memory RateLimitStore, the full buckets would be swept periodically.
+type MemoryRateLimitStore struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// NewMemoryRateLimitStore returns a new in memory RateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > kRateLimitSweepInterval {
+		s.sweep(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Requests), updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.limit = limit
+	bucket.refill(now)
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = bucket.duration(1 - bucket.tokens)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = bucket.duration(float64(limit.Requests) - bucket.tokens)
+	return result, nil
+}
+
+// sweep removes the buckets which are full already, should be called with the lock.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if bucket.refill(now); bucket.tokens >= float64(bucket.limit.Requests) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	rate := float64(b.limit.Requests) / float64(b.limit.Period)
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+rate*float64(now.Sub(b.updated)))
+	b.updated = now
+}
+
+// duration returns the duration to refill the tokens.
+func (b *tokenBucket) duration(tokens float64) time.Duration {
+	rate := float64(b.limit.Requests) / float64(b.limit.Period)
+	return time.Duration(tokens / rate)
+}
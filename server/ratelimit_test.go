@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRateLimitWare(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Middleware(NewRateLimitWare(RateLimitOptions{
+		Limit: RateLimit{Requests: 2, Period: time.Minute},
+		Routes: map[string]RateLimit{
+			"Login":  {Requests: 1, Period: time.Hour},
+			"Health": {Requests: -1},
+		},
+		Key: RateLimitByHeader("X-API-Key"),
+	}))
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("ok"))
+		return ctx
+	}
+	srv.Get("/items", "Items", ok)
+	srv.Get("/users", "Users", ok)
+	srv.Post("/login", "Login", ok)
+	srv.Get("/health", "Health", ok)
+
+	request := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, nil)
+		r.Header.Set("X-API-Key", apiKey)
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("GET", "/items", "a")
+	if w.Code != 200 || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("First request should be allowed with headers, %d %v", w.Code, w.Header())
+	}
+	if w := request("GET", "/users", "a"); w.Code != 200 || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Default limit should be shared by the routes, %d %v", w.Code, w.Header())
+	}
+	w = request("GET", "/items", "a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != kContentProblem {
+		t.Fatalf("Exceeded request should get 429 problem, %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("Retry-After and reset mismatched, %v", w.Header())
+	}
+	if w := request("GET", "/items", "b"); w.Code != 200 {
+		t.Errorf("Other keys should have separate buckets, got %d", w.Code)
+	}
+
+	if w := request("POST", "/login", "a"); w.Code != 200 || w.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("Route limit should have its own bucket, %d %v", w.Code, w.Header())
+	}
+	if w := request("POST", "/login", "a"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Route limit should be exceeded, got %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := request("GET", "/health", "a"); w.Code != 200 || w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("Unlimited route should not be limited, %d %v", w.Code, w.Header())
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 1, Period: 20 * time.Millisecond}
+	if result, _ := store.Take("k", limit); !result.Allowed {
+		t.Errorf("First take should be allowed")
+	}
+	if result, _ := store.Take("k", limit); result.Allowed || result.RetryAfter <= 0 {
+		t.Errorf("Second take should be denied with retry after, %+v", result)
+	}
+	time.Sleep(25 * time.Millisecond)
+	if result, _ := store.Take("k", limit); !result.Allowed {
+		t.Errorf("Token should be refilled after the period")
+	}
+	store.sweep(time.Now().Add(time.Second))
+	if len(store.buckets) != 0 {
+		t.Errorf("Full buckets should be swept, %d left", len(store.buckets))
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 )
 
+var errHijackUnsupported = fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+
 // ResponseWriter is a wrapper around http.ResponseWriter that provides extra information about
 // the response. It is recommended that middleware handlers use this construct to wrap a responsewriter
 // if the functionality calls for it.
@@ -26,15 +28,22 @@ type ResponseWriter interface {
 
 type beforeFunc func(ResponseWriter)
 
+// rawSizeSetter is implemented by the ResponseWriter which can record the raw size of the encoded body,
+// e.g. set by the CompressWare.
+type rawSizeSetter interface {
+	setRawSize(size int)
+}
+
 // NewResponseWriter creates a ResponseWriter that wraps an http.ResponseWriter
 func NewResponseWriter(rw http.ResponseWriter) ResponseWriter {
-	return &responseWriter{rw, 0, 0, nil}
+	return &responseWriter{ResponseWriter: rw}
 }
 
 type responseWriter struct {
 	http.ResponseWriter
 	status      int
 	size        int
+	rawSize     int
 	beforeFuncs []beforeFunc
 }
 
@@ -62,6 +71,19 @@ func (rw *responseWriter) Size() int {
 	return rw.size
 }
 
+// RawSize returns the size of the response body before encoding, e.g. the compression, it is the same as the
+// Size if the body is not encoded.
+func (rw *responseWriter) RawSize() int {
+	if rw.rawSize > 0 {
+		return rw.rawSize
+	}
+	return rw.size
+}
+
+func (rw *responseWriter) setRawSize(size int) {
+	rw.rawSize = size
+}
+
 func (rw *responseWriter) Written() bool {
 	return rw.status != 0
 }
@@ -73,13 +95,23 @@ func (rw *responseWriter) Before(before func(ResponseWriter)) {
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
 	if !ok {
-		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+		return nil, nil, errHijackUnsupported
 	}
-	return hijacker.Hijack()
+	conn, brw, err := hijacker.Hijack()
+	if err == nil && !rw.Written() {
+		// the protocol is switched, e.g. websocket, keep the status for the middlewares
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
 }
 
 func (rw *responseWriter) CloseNotify() <-chan bool {
-	return rw.ResponseWriter.(http.CloseNotifier).CloseNotify()
+	notifier, ok := rw.ResponseWriter.(http.CloseNotifier)
+	if !ok {
+		// never notified if the underlying ResponseWriter doesn't support it
+		return make(chan bool)
+	}
+	return notifier.CloseNotify()
 }
 
 func (rw *responseWriter) callBefore() {
@@ -2,15 +2,20 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"reflect"
 
 	"golang.org/x/net/context"
 )
 
+var requestType = reflect.TypeOf((*http.Request)(nil))
+
 // ResourceHandler is a function type for the restful resources to define a json restful api
 type ResourceHandler func(ctx context.Context, r *http.Request) (code int, data interface{})
 
-// RestfulHandlerAdapter is a function type to adapt a ResourceHandler to Handler
+// RestfulHandlerAdapter is a function type to adapt a ResourceHandler to Handler, the handler data has been shaped
+// by the "fields" and "expand" queries already.
 type RestfulHandlerAdapter func(handle ResourceHandler) Handler
 
 // Resouce is an interface to define the basic restful api entry points
@@ -23,6 +28,12 @@ type Resource interface {
 	Head(ctx context.Context, r *http.Request) (code int, data interface{})
 }
 
+// Locator can be implemented by the resource data to set the "Location" header for the 201 Created and 202 Accepted
+// responses, e.g. the Job.
+type Locator interface {
+	Location() string
+}
+
 // RestfulHandlerAdapter will set the server's restful handler adapter
 func (s *Server) RestfulHandlerAdapter(adapter RestfulHandlerAdapter) {
 	if adapter != nil {
@@ -30,23 +41,105 @@ func (s *Server) RestfulHandlerAdapter(adapter RestfulHandlerAdapter) {
 	}
 }
 
-// AddRestfulResource will register the resource to the path with given routing name
+// AddRestfulResource will register the resource to the path with given routing name, the resource can implement
+// the Expander interface to support the "expand" query.
 func (s *Server) AddRestfulResource(path string, name string, resource Resource) {
-	adapter := s.restfulAdapter
-	if adapter == nil {
-		adapter = s.defaultRestfulAdapter
+	s.addRestfulResource(s, path, name, resource)
+}
+
+func (s *Server) addRestfulResource(mux Muxer, path string, name string, resource Resource) {
+	restAdapter := s.restfulAdapter
+	if restAdapter == nil {
+		restAdapter = s.defaultRestfulAdapter
+	}
+	adapter := func(handle ResourceHandler) Handler {
+		return restAdapter(shapeHandler(resource, handle))
+	}
+	mux.Get(path, "Get_"+name, adapter(resource.Get))
+	mux.Post(path, "Post_"+name, adapter(resource.Post))
+	mux.Delete(path, "Delete_"+name, adapter(resource.Delete))
+	mux.Put(path, "Put_"+name, adapter(resource.Put))
+	mux.Patch(path, "Patch_"+name, adapter(resource.Patch))
+	mux.Head(path, "Head_"+name, adapter(resource.Head))
+}
+
+// HandleJson registers the typed json handler, the json body would be bound into the optional third argument, and
+// the result would be responded by the restful handler adapter. The types are described for the OpenAPI document.
+//
+//	srv.HandleJson("POST", "/users", "CreateUser", func(ctx context.Context, r *http.Request, req *CreateUser) (int, *User) {
+//		...
+//		return http.StatusCreated, user
+//	})
+func (s *Server) HandleJson(method, path, name string, fn interface{}) {
+	value := reflect.ValueOf(fn)
+	t := value.Type()
+	if t.Kind() != reflect.Func || t.NumIn() < 2 || t.NumIn() > 3 || t.In(0) != contextType || t.In(1) != requestType ||
+		t.NumOut() != 2 || t.Out(0).Kind() != reflect.Int {
+		panic(fmt.Sprintf("server: %s is not a json handler like func(context.Context, *http.Request, *T) (int, R)", t))
+	}
+	var bodyType reflect.Type
+	if t.NumIn() == 3 {
+		bodyType = t.In(2)
+	}
+	handle := func(ctx context.Context, r *http.Request) (int, interface{}) {
+		args := []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(r)}
+		if bodyType != nil {
+			body, status, err := bindJsonBody(r, bodyType)
+			if err != nil {
+				return status, err.Error()
+			}
+			args = append(args, body)
+		}
+		outs := value.Call(args)
+		return int(outs[0].Int()), outs[1].Interface()
+	}
+	restAdapter := s.restfulAdapter
+	if restAdapter == nil {
+		restAdapter = s.defaultRestfulAdapter
 	}
-	s.Get(path, "Get_"+name, adapter(resource.Get))
-	s.Post(path, "Post_"+name, adapter(resource.Post))
-	s.Delete(path, "Delete_"+name, adapter(resource.Delete))
-	s.Put(path, "Put_"+name, adapter(resource.Put))
-	s.Patch(path, "Patch_"+name, adapter(resource.Patch))
-	s.Head(path, "Head_"+name, adapter(resource.Head))
+	s.Handle(method, path, name, restAdapter(shapeHandler(nil, handle)))
+	route := s.routes[len(s.routes)-1]
+	route.request, route.response = bodyType, t.Out(1)
 }
 
+// bindJsonBody decodes the request body within the size limit into the value of the type.
+func bindJsonBody(r *http.Request, t reflect.Type) (reflect.Value, int, error) {
+	elemType := t
+	if t.Kind() == reflect.Ptr {
+		elemType = t.Elem()
+	}
+	body := reflect.New(elemType)
+	data, err := readLimitedBody(r)
+	if err == ErrBodyTooLarge {
+		return body, http.StatusRequestEntityTooLarge, err
+	}
+	if err == nil && len(data) > 0 {
+		err = json.Unmarshal(data, body.Interface())
+	}
+	if err != nil {
+		return body, http.StatusBadRequest, fmt.Errorf("Cannot decode the request body, %s", err)
+	}
+	if t.Kind() != reflect.Ptr {
+		body = body.Elem()
+	}
+	return body, http.StatusOK, nil
+}
+
+// defaultRestfulAdapter marshals the resource data as json. The data of a channel or ResourceIterator would be
+// streamed with the items pruned to the "fields" query, the handler context would be cancelled when the client
+// disconnects.
 func (s *Server) defaultRestfulAdapter(handle ResourceHandler) Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
-		status, v := handle(ctx, r)
+		handleCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		status, v := handle(handleCtx, r)
+		if isStreamData(v) {
+			streamResource(handleCtx, cancel, w, r, status, v)
+			return ctx
+		}
+		if locator, ok := v.(Locator); ok && (status == http.StatusCreated || status == http.StatusAccepted) {
+			w.Header().Set("Location", locator.Location())
+		}
 		data, err := json.MarshalIndent(v, "", "  ")
 		if err != nil {
 			status = http.StatusInternalServerError
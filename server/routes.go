@@ -64,7 +64,7 @@ func (s *Server) Reverse(name string, params ...interface{}) string {
 			}
 		}
 	}
-	return httprouter.CleanPath("/" + strings.Join(parts, "/"))[1:]
+	return httprouter.CleanPath("/" + strings.Join(parts, "/"))
 }
 
 // Assets would reverse the assets url, e.g. s.Assets("images/test.png") gives us "/assets/images/test.png"
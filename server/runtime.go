@@ -67,6 +67,23 @@ func (lc *LatencyCounter) Stat() LatencyStat {
 	return stat
 }
 
+// Percentile returns the latency at the percentile between 0 and 100 of the recent requests, zero if no data.
+func (lc *LatencyCounter) Percentile(percent int) time.Duration {
+	lc.RLock()
+	latency := make([]int64, len(lc.latency))
+	copy(latency, lc.latency)
+	lc.RUnlock()
+	if len(latency) == 0 {
+		return 0
+	}
+	sort.Sort(int64Slice(latency))
+	index := len(latency) * percent / 100
+	if index >= len(latency) {
+		index = len(latency) - 1
+	}
+	return time.Duration(latency[index])
+}
+
 func NewLatencyCounter(size int) *LatencyCounter {
 	return &LatencyCounter{
 		latency: make([]int64, 0, size),
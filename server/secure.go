@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	kCSPNonceKey = "inter_ctx_key_csp_nonce"
+
+	kCSPNoncePlaceholder = "{nonce}"
+	kCSPReportMaxSize    = 64 * 1024
+	kDefaultCSP          = "default-src 'self'; script-src 'self' {nonce}; style-src 'self' {nonce}; object-src 'none'; " +
+		"base-uri 'self'; frame-ancestors 'none'"
+)
+
+// SecurityOptions defines the options of the SecurityWare, the empty values are the secure defaults.
+type SecurityOptions struct {
+	// The max age of the Strict-Transport-Security, default is 1 year, negative means not set
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// The X-Frame-Options, default is "DENY", "-" means not set
+	FrameOptions string
+	// The Referrer-Policy, default is "strict-origin-when-cross-origin", "-" means not set
+	ReferrerPolicy string
+	// The Permissions-Policy, default is "camera=(), microphone=(), geolocation=()", "-" means not set
+	PermissionsPolicy string
+	// The Content-Security-Policy, the "{nonce}" is replaced by the 'nonce-...' source of the request, "-" means
+	// not set, default is
+	//	default-src 'self'; script-src 'self' {nonce}; style-src 'self' {nonce}; object-src 'none'; base-uri 'self'; frame-ancestors 'none'
+	CSP string
+	// The CSP is sent as Content-Security-Policy-Report-Only, so the violations are only reported
+	ReportOnly bool
+	// The report-uri of the CSP, e.g. the path of EnableCSPReports
+	ReportURI string
+}
+
+// SecurityWare sets the security headers of the responses, the Content-Security-Policy has a nonce for each request
+// which can be used by the templates to mark the inline scripts and styles, with the DefaultRouteFuncs and the
+// request context in the binding:
+//
+//	<script nonce="{{ cspNonce .Ctx }}">...</script>
+type SecurityWare struct {
+	opt  SecurityOptions
+	hsts string
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *SecurityWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	header := w.Header()
+	if m.hsts != "" {
+		header.Set("Strict-Transport-Security", m.hsts)
+	}
+	header.Set("X-Content-Type-Options", "nosniff")
+	setSecurityHeader(header, "X-Frame-Options", m.opt.FrameOptions)
+	setSecurityHeader(header, "Referrer-Policy", m.opt.ReferrerPolicy)
+	setSecurityHeader(header, "Permissions-Policy", m.opt.PermissionsPolicy)
+	if m.opt.CSP == "-" {
+		return next(ctx, w, r)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "Failed to generate the CSP nonce")
+		return ctx
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	csp := strings.Replace(m.opt.CSP, kCSPNoncePlaceholder, "'nonce-"+nonce+"'", -1)
+	if m.opt.ReportURI != "" {
+		csp += "; report-uri " + m.opt.ReportURI
+	}
+	if m.opt.ReportOnly {
+		header.Set("Content-Security-Policy-Report-Only", csp)
+	} else {
+		header.Set("Content-Security-Policy", csp)
+	}
+	return next(context.WithValue(ctx, kCSPNonceKey, nonce), w, r)
+}
+
+// NewSecurityWare returns a new SecurityWare.
+func NewSecurityWare(opt SecurityOptions) Middleware {
+	if opt.FrameOptions == "" {
+		opt.FrameOptions = "DENY"
+	}
+	if opt.ReferrerPolicy == "" {
+		opt.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if opt.PermissionsPolicy == "" {
+		opt.PermissionsPolicy = "camera=(), microphone=(), geolocation=()"
+	}
+	if opt.CSP == "" {
+		opt.CSP = kDefaultCSP
+	}
+	if opt.HSTSMaxAge == 0 {
+		opt.HSTSMaxAge = 365 * 24 * time.Hour
+	}
+	m := &SecurityWare{opt: opt}
+	if opt.HSTSMaxAge > 0 {
+		m.hsts = fmt.Sprintf("max-age=%d", int64(opt.HSTSMaxAge.Seconds()))
+		if opt.HSTSIncludeSubdomains {
+			m.hsts += "; includeSubDomains"
+		}
+		if opt.HSTSPreload {
+			m.hsts += "; preload"
+		}
+	}
+	return m
+}
+
+func setSecurityHeader(header http.Header, name, value string) {
+	if value != "-" {
+		header.Set(name, value)
+	}
+}
+
+// CSPNonce returns the CSP nonce of the request set by the SecurityWare.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(kCSPNonceKey).(string)
+	return nonce
+}
+
+// CSPReport is the CSP violation report sent by the browsers.
+type CSPReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+	StatusCode         int    `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// EnableCSPReports registers the endpoint receiving the CSP violation reports at the path with the given routing
+// name, both the "application/csp-report" of the report-uri and the "application/reports+json" of the Reporting API
+// are accepted. The reports are logged if the handle is nil. The browsers send the reports without the credentials
+// and the CSRF token, so the route should be public for the AuthWare and exempted from the CSRFWare.
+func (s *Server) EnableCSPReports(path string, name string, handle func(ctx context.Context, report *CSPReport)) {
+	if handle == nil {
+		handle = func(ctx context.Context, report *CSPReport) {
+			log.Warnf("CSP violation: %q blocked %q on %q", report.EffectiveDirective, report.BlockedURI, report.DocumentURI)
+		}
+	}
+	s.Post(path, name, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, kCSPReportMaxSize))
+		if err != nil {
+			WriteProblem(w, http.StatusBadRequest, "Cannot read the CSP report")
+			return ctx
+		}
+		reports, err := decodeCSPReports(data)
+		if err != nil {
+			WriteProblem(w, http.StatusBadRequest, "Cannot decode the CSP report, "+err.Error())
+			return ctx
+		}
+		for _, report := range reports {
+			handle(ctx, report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return ctx
+	})
+}
+
+func decodeCSPReports(data []byte) ([]*CSPReport, error) {
+	var legacy struct {
+		Report *CSPReport `json:"csp-report"`
+	}
+	if err := json.Unmarshal(data, &legacy); err == nil && legacy.Report != nil {
+		return []*CSPReport{legacy.Report}, nil
+	}
+
+	var reports []struct {
+		Type string `json:"type"`
+		Body struct {
+			DocumentURL        string `json:"documentURL"`
+			Referrer           string `json:"referrer"`
+			EffectiveDirective string `json:"effectiveDirective"`
+			OriginalPolicy     string `json:"originalPolicy"`
+			Disposition        string `json:"disposition"`
+			BlockedURL         string `json:"blockedURL"`
+			SourceFile         string `json:"sourceFile"`
+			LineNumber         int    `json:"lineNumber"`
+			ColumnNumber       int    `json:"columnNumber"`
+			StatusCode         int    `json:"statusCode"`
+			Sample             string `json:"sample"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, err
+	}
+	result := []*CSPReport{}
+	for _, report := range reports {
+		if report.Type != "csp-violation" {
+			continue
+		}
+		body := report.Body
+		result = append(result, &CSPReport{
+			DocumentURI:        body.DocumentURL,
+			Referrer:           body.Referrer,
+			ViolatedDirective:  body.EffectiveDirective,
+			EffectiveDirective: body.EffectiveDirective,
+			OriginalPolicy:     body.OriginalPolicy,
+			Disposition:        body.Disposition,
+			BlockedURI:         body.BlockedURL,
+			SourceFile:         body.SourceFile,
+			LineNumber:         body.LineNumber,
+			ColumnNumber:       body.ColumnNumber,
+			StatusCode:         body.StatusCode,
+			ScriptSample:       body.Sample,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSecurityWare(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.Middleware(NewSecurityWare(SecurityOptions{HSTSIncludeSubdomains: true, FrameOptions: "-", ReportURI: "/csp"}))
+	tmpl := template.Must(template.New("page").Funcs(srv.DefaultRouteFuncs()).Parse(
+		`<script nonce="{{ cspNonce .Ctx }}"></script>`))
+	srv.Get("/page", "Page", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		tmpl.Execute(w, map[string]interface{}{"Ctx": ctx})
+		return ctx
+	})
+	var reports []*CSPReport
+	srv.EnableCSPReports("/csp", "CSPReport", func(ctx context.Context, report *CSPReport) {
+		reports = append(reports, report)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/page", nil)
+	srv.ServeHTTP(w, r)
+	header := w.Header()
+	if header.Get("Strict-Transport-Security") != "max-age=31536000; includeSubDomains" ||
+		header.Get("X-Content-Type-Options") != "nosniff" || header.Get("X-Frame-Options") != "" ||
+		header.Get("Referrer-Policy") == "" || header.Get("Permissions-Policy") == "" {
+		t.Errorf("Security headers mismatched, %v", header)
+	}
+	body := w.Body.String()
+	nonce := strings.TrimSuffix(strings.TrimPrefix(body, `<script nonce="`), `"></script>`)
+	csp := header.Get("Content-Security-Policy")
+	if nonce == "" || nonce == body || !strings.Contains(csp, "script-src 'self' 'nonce-"+nonce+"'") ||
+		!strings.HasSuffix(csp, "; report-uri /csp") {
+		t.Errorf("CSP should have the nonce rendered in the template, %q %q", csp, body)
+	}
+	w2 := httptest.NewRecorder()
+	srv.ServeHTTP(w2, r)
+	if w2.Body.String() == body {
+		t.Errorf("CSP nonce should be generated for each request")
+	}
+
+	for _, c := range []struct {
+		contentType, body string
+	}{
+		{"application/csp-report", `{"csp-report": {"document-uri": "https://a.com/page", "blocked-uri": "inline", "effective-directive": "script-src"}}`},
+		{"application/reports+json", `[{"type": "csp-violation", "body": {"documentURL": "https://a.com/page", "blockedURL": "inline", "effectiveDirective": "script-src"}}, {"type": "deprecation"}]`},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/csp", bytes.NewBufferString(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("CSP report should be accepted, %d %s", w.Code, w.Body.String())
+		}
+	}
+	if len(reports) != 2 || reports[1].BlockedURI != "inline" || reports[1].DocumentURI != "https://a.com/page" {
+		t.Errorf("CSP reports mismatched, %v", reports)
+	}
+
+	srv = New(context.Background(), false)
+	srv.Middleware(NewSecurityWare(SecurityOptions{ReportOnly: true, HSTSMaxAge: -1}))
+	srv.Get("/page", "Page", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		return ctx
+	})
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Header().Get("Content-Security-Policy-Report-Only") == "" || w.Header().Get("Content-Security-Policy") != "" ||
+		w.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("Report only CSP should be set without HSTS, %v", w.Header())
+	}
+}
@@ -11,6 +11,9 @@ package server
 import (
 	"html/template"
 	"net/http"
+	"reflect"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -25,6 +28,8 @@ type Handler func(ctx context.Context, w http.ResponseWriter, r *http.Request) c
 
 const (
 	kHrParamsKey     = "inter_ctx_key_hrparams"
+	kRouteKey        = "inter_ctx_key_route"
+	kRequestKey      = "inter_ctx_key_request"
 	kGracefulTimeout = 10
 )
 
@@ -49,6 +54,15 @@ type Server struct {
 	extraAssetsJson    string
 	assetsPrefix       string
 	namedRoutes        map[string]string
+	routes             []*routeInfo
+	openAPIRoute       string
+	versionOpt         VersionOptions
+	versionAccept      *regexp.Regexp
+	versionMuxers      map[int]*VersionMuxer
+	versionedRoutes    map[string]*routeInfo
+	shutdownHooks      []func()
+	shutdownOnce       sync.Once
+	cors               *CORSWare
 	restfulAdapter     RestfulHandlerAdapter
 	debug              bool
 }
@@ -63,17 +77,44 @@ func (s *Server) Run(addr string) error {
 		Timeout: timeout,
 		Server: &http.Server{
 			Addr:    addr,
-			Handler: s.router,
+			Handler: s,
 		},
 	}
 	log.Infof("Server is listening on %s", addr)
-	return s.srv.ListenAndServe()
+	err := s.srv.ListenAndServe()
+	s.shutdown()
+	return err
 }
 
 func (s *Server) Stop(timeout time.Duration) {
 	s.srv.Stop(timeout)
 }
 
+// OnShutdown registers a function which would be called when the server is shutdown, e.g. cancel the background works.
+func (s *Server) OnShutdown(fn func()) {
+	s.shutdownHooks = append(s.shutdownHooks, fn)
+}
+
+func (s *Server) shutdown() {
+	s.shutdownOnce.Do(func() {
+		for _, fn := range s.shutdownHooks {
+			fn()
+		}
+	})
+}
+
+// ServeHTTP makes the server an http.Handler, the requests would be dispatched by the router.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cors != nil {
+		s.cors.ServeHTTP(s.baseCtx, w, r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+			s.router.ServeHTTP(w, r)
+			return ctx
+		})
+		return
+	}
+	s.router.ServeHTTP(w, r)
+}
+
 // EnableAssetsPrefix can be used to add assets prefix for assets reverse, like CDN host name.
 func (s *Server) EnableAssetsPrefix(prefix string) {
 	s.assetsPrefix = prefix
@@ -86,8 +127,10 @@ func (s *Server) Middleware(ware Middleware) {
 
 // Handle: basic interface which register a http request and handler to the router
 func (s *Server) Handle(method, path, name string, handle Handler) {
-	s.router.Handle(method, path, s.hrAdapt(handle))
+	route := &routeInfo{method: method, path: path, name: name}
+	s.router.Handle(method, path, s.hrAdapt(route, handle))
 	s.namedRoutes[name] = path
+	s.routes = append(s.routes, route)
 }
 
 // Get will register a 'GET' request handler to the router.
@@ -123,7 +166,7 @@ func (s *Server) Delete(path string, name string, handle Handler) {
 // NotFound wil register a 404 NotFound handler to the router.
 func (s *Server) NotFound(handle Handler) {
 	if handle != nil {
-		h := s.hrAdapt(handle)
+		h := s.hrAdapt(nil, handle)
 		s.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			h(w, r, nil)
 		})
@@ -133,7 +176,7 @@ func (s *Server) NotFound(handle Handler) {
 // MethodNotAllowed will register a 405 handler to the router
 func (s *Server) MethodNotAllowed(handle Handler) {
 	if handle != nil {
-		h := s.hrAdapt(handle)
+		h := s.hrAdapt(nil, handle)
 		s.router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			h(w, r, nil)
 		})
@@ -141,7 +184,9 @@ func (s *Server) MethodNotAllowed(handle Handler) {
 }
 
 // DefaultRouteFuncs provides a FuncMap for the renderer includes 'assets' and 'urlReverse'
-// so that you can use those functions inside the templates.
+// so that you can use those functions inside the templates. The 'csrfToken', 'csrfField' and
+// 'csrfMeta' take the request context to render the CSRF token of the CSRFWare, and the 'cspNonce'
+// renders the CSP nonce of the SecurityWare.
 func (s *Server) DefaultRouteFuncs() template.FuncMap {
 	return template.FuncMap{
 		"assets": func(path string) (string, error) {
@@ -150,11 +195,16 @@ func (s *Server) DefaultRouteFuncs() template.FuncMap {
 		"urlReverse": func(name string, params ...interface{}) (string, error) {
 			return s.Reverse(name, params...), nil
 		},
+		"csrfToken": CSRFToken,
+		"csrfField": CSRFField,
+		"csrfMeta":  CSRFMeta,
+		"cspNonce":  CSPNonce,
 	}
 }
 
-// htAdapt adapts a sweb Handler to the httprouter Handle
-func (s *Server) hrAdapt(fn Handler) httprouter.Handle {
+// htAdapt adapts a sweb Handler to the httprouter Handle, the matched route would be injected into the context, and
+// the context would be cancelled after the request is served.
+func (s *Server) hrAdapt(route *routeInfo, fn Handler) httprouter.Handle {
 	core := func(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
 		// we are inside the onion core, so the next would be ignored
 		if s.debug {
@@ -164,7 +214,16 @@ func (s *Server) hrAdapt(fn Handler) httprouter.Handle {
 	}
 	handler := buildOnion(append(s.wares, MiddleFn(core)))
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		ctx := s.baseCtx
+		if route != nil && route.batch && isBatchSubRequest(w) {
+			WriteProblem(w, http.StatusBadRequest, "The batch request cannot be nested")
+			return
+		}
+		ctx, cancel := context.WithCancel(s.baseCtx)
+		defer cancel()
+		ctx = context.WithValue(ctx, kRequestKey, r)
+		if route != nil {
+			ctx = context.WithValue(ctx, kRouteKey, s.selectVersion(route, r))
+		}
 		if len(params) > 0 {
 			ctx = newContextWithParams(ctx, params)
 		}
@@ -172,6 +231,21 @@ func (s *Server) hrAdapt(fn Handler) httprouter.Handle {
 	}
 }
 
+// routeInfo keeps the registered route for the route table.
+type routeInfo struct {
+	method   string
+	path     string
+	name     string
+	request  reflect.Type
+	response reflect.Type
+	batch    bool
+	// the API version and the handler of the versioned route
+	version int
+	handle  Handler
+	// the versioned routes of the unprefixed route, selected while routing
+	versions map[int]*routeInfo
+}
+
 // Params extracts the param from url, e.g. "/hello/:name" -> server.Params(ctx, "name")
 func Params(ctx context.Context, key string) string {
 	if params, ok := ctx.Value(kHrParamsKey).(httprouter.Params); !ok {
@@ -181,6 +255,22 @@ func Params(ctx context.Context, key string) string {
 	}
 }
 
+// RouteName returns the name of the matched route, empty for the NotFound and MethodNotAllowed handlers.
+func RouteName(ctx context.Context) string {
+	if route, ok := ctx.Value(kRouteKey).(*routeInfo); ok {
+		return route.name
+	}
+	return ""
+}
+
+// RoutePath returns the path pattern of the matched route, e.g. "/hello/:name".
+func RoutePath(ctx context.Context) string {
+	if route, ok := ctx.Value(kRouteKey).(*routeInfo); ok {
+		return route.path
+	}
+	return ""
+}
+
 // New a go web server with context as parent context
 func New(ctx context.Context, isDebug bool) *Server {
 	if isDebug {
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kShedQueueTimeout = 100 * time.Millisecond
+	kShedRetryAfter   = time.Second
+	kShedLatencySize  = 100
+	kShedProbeEvery   = 10
+)
+
+// Priority is the priority class of the routes for the load shedding.
+type Priority int
+
+const (
+	// PriorityLow routes are shed first when the latency is over the target, and never queued.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the default priority, shed when the latency is over twice the target.
+	PriorityNormal
+	// PriorityCritical routes are never limited or shed, e.g. the health checks and admin.
+	PriorityCritical
+)
+
+// ConcurrencyOptions defines the options of the ConcurrencyWare.
+type ConcurrencyOptions struct {
+	// The max in-flight requests of the server, zero means no limit
+	MaxInFlight int
+	// The max in-flight requests by the route names
+	Routes map[string]int
+	// The max count of the requests waiting for the in-flight slots, zero means no queueing
+	QueueSize int
+	// The max waiting time in the queue, default is 100ms
+	QueueTimeout time.Duration
+	// The target of the p95 latency, zero means no latency based shedding
+	LatencyTarget time.Duration
+	// The latency counter of the served requests, default is a new one with 100 samples
+	Latency *LatencyCounter
+	// The priority classes by the route names, default is PriorityNormal
+	Priorities map[string]Priority
+	// The Retry-After of the shed requests, default is 1s
+	RetryAfter time.Duration
+}
+
+// ConcurrencyWare is the middleware capping the in-flight requests globally and per route, the requests would wait
+// briefly in the queue for the slots, and be shed with 503 and Retry-After if the queue is full or timed out. It also
+// sheds the load when the p95 latency is over the target, the low priority routes first.
+type ConcurrencyWare struct {
+	opt      ConcurrencyOptions
+	global   chan struct{}
+	routes   map[string]chan struct{}
+	waiting  int32
+	inFlight int32
+	sheds    uint32
+}
+
+// ServeHTTP implements the Middleware interface.
+func (m *ConcurrencyWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+	name := RouteName(ctx)
+	priority := m.opt.Priorities[name]
+	if priority >= PriorityCritical {
+		return next(ctx, w, r)
+	}
+	if m.overloaded(priority) {
+		return m.shed(ctx, w, "Server is overloaded")
+	}
+
+	var timer *time.Timer
+	waited := false
+	acquire := func(slots chan struct{}) bool {
+		if slots == nil {
+			return true
+		}
+		select {
+		case slots <- struct{}{}:
+			return true
+		default:
+		}
+		if priority < PriorityNormal {
+			return false
+		}
+		if !waited {
+			if int(atomic.AddInt32(&m.waiting, 1)) > m.opt.QueueSize {
+				atomic.AddInt32(&m.waiting, -1)
+				return false
+			}
+			waited = true
+			timer = time.NewTimer(m.opt.QueueTimeout)
+		}
+		select {
+		case slots <- struct{}{}:
+			return true
+		case <-timer.C:
+			return false
+		}
+	}
+	release := func(slots chan struct{}) {
+		if slots != nil {
+			<-slots
+		}
+	}
+
+	routeSlots := m.routes[name]
+	acquired := acquire(routeSlots)
+	if acquired && !acquire(m.global) {
+		release(routeSlots)
+		acquired = false
+	}
+	if waited {
+		timer.Stop()
+		atomic.AddInt32(&m.waiting, -1)
+	}
+	if !acquired {
+		return m.shed(ctx, w, "Too many requests in flight")
+	}
+	defer release(routeSlots)
+	defer release(m.global)
+
+	atomic.AddInt32(&m.inFlight, 1)
+	defer atomic.AddInt32(&m.inFlight, -1)
+	start := time.Now()
+	newCtx := next(ctx, w, r)
+	m.opt.Latency.Add(time.Since(start))
+	return newCtx
+}
+
+// NewConcurrencyWare returns a new ConcurrencyWare.
+func NewConcurrencyWare(opt ConcurrencyOptions) *ConcurrencyWare {
+	if opt.QueueTimeout <= 0 {
+		opt.QueueTimeout = kShedQueueTimeout
+	}
+	if opt.RetryAfter <= 0 {
+		opt.RetryAfter = kShedRetryAfter
+	}
+	if opt.Latency == nil {
+		opt.Latency = NewLatencyCounter(kShedLatencySize)
+	}
+	m := &ConcurrencyWare{
+		opt:    opt,
+		routes: make(map[string]chan struct{}),
+	}
+	if opt.MaxInFlight > 0 {
+		m.global = make(chan struct{}, opt.MaxInFlight)
+	}
+	for name, max := range opt.Routes {
+		if max > 0 {
+			m.routes[name] = make(chan struct{}, max)
+		}
+	}
+	return m
+}
+
+// InFlight returns the count of the in-flight requests.
+func (m *ConcurrencyWare) InFlight() int {
+	return int(atomic.LoadInt32(&m.inFlight))
+}
+
+// Waiting returns the count of the requests waiting in the queue.
+func (m *ConcurrencyWare) Waiting() int {
+	return int(atomic.LoadInt32(&m.waiting))
+}
+
+// overloaded tells if the request should be shed by the latency, every 10th shed request would be let through as
+// the probe so that the latency samples can recover.
+func (m *ConcurrencyWare) overloaded(priority Priority) bool {
+	if m.opt.LatencyTarget <= 0 {
+		return false
+	}
+	target := m.opt.LatencyTarget
+	if priority >= PriorityNormal {
+		target *= 2
+	}
+	if m.opt.Latency.Percentile(95) <= target {
+		return false
+	}
+	return atomic.AddUint32(&m.sheds, 1)%kShedProbeEvery != 0
+}
+
+func (m *ConcurrencyWare) shed(ctx context.Context, w http.ResponseWriter, detail string) context.Context {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(m.opt.RetryAfter)))
+	WriteProblem(w, http.StatusServiceUnavailable, detail)
+	return ctx
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestConcurrencyWare(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	ware := NewConcurrencyWare(ConcurrencyOptions{
+		MaxInFlight:  1,
+		QueueSize:    1,
+		QueueTimeout: time.Second,
+		Priorities:   map[string]Priority{"Health": PriorityCritical},
+	})
+	srv := New(context.Background(), false)
+	srv.Middleware(ware)
+	srv.Get("/slow", "Slow", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("slow"))
+		return ctx
+	})
+	srv.Get("/health", "Health", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Write([]byte("ok"))
+		return ctx
+	})
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	codes := make(chan int, 2)
+	go func() { codes <- request("/slow").Code }()
+	<-started
+	go func() { codes <- request("/slow").Code }()
+	for ware.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := request("/slow")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Request should be shed when the queue is full, %d %v", w.Code, w.Header())
+	}
+	if w := request("/health"); w.Code != 200 {
+		t.Errorf("Critical route should never be shed, got %d", w.Code)
+	}
+	if ware.InFlight() != 1 {
+		t.Errorf("In-flight requests should be 1, got %d", ware.InFlight())
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != 200 {
+			t.Errorf("Queued request should be served, got %d", code)
+		}
+	}
+
+	ware = NewConcurrencyWare(ConcurrencyOptions{
+		MaxInFlight:  1,
+		QueueTimeout: 10 * time.Millisecond,
+		Routes:       map[string]int{"Slow": 1},
+	})
+	srv = New(context.Background(), false)
+	srv.Middleware(ware)
+	srv.Get("/slow", "Slow", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		time.Sleep(50 * time.Millisecond)
+		return ctx
+	})
+	go request("/slow")
+	for ware.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if w := request("/slow"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Request should be shed without queue, got %d", w.Code)
+	}
+}
+
+func TestConcurrencyWareLatency(t *testing.T) {
+	latency := NewLatencyCounter(10)
+	for i := 0; i < 10; i++ {
+		latency.Add(150 * time.Millisecond)
+	}
+	ware := NewConcurrencyWare(ConcurrencyOptions{
+		LatencyTarget: 100 * time.Millisecond,
+		Latency:       latency,
+		Priorities:    map[string]Priority{"Report": PriorityLow, "Health": PriorityCritical},
+	})
+	srv := New(context.Background(), false)
+	srv.Middleware(ware)
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		return ctx
+	}
+	srv.Get("/report", "Report", ok)
+	srv.Get("/items", "Items", ok)
+	srv.Get("/health", "Health", ok)
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := request("/report"); code != http.StatusServiceUnavailable {
+		t.Errorf("Low priority should be shed over the latency target, got %d", code)
+	}
+	if code := request("/items"); code != 200 {
+		t.Errorf("Normal priority should not be shed under twice the target, got %d", code)
+	}
+	for i := 0; i < 10; i++ {
+		latency.Add(time.Second)
+	}
+	shed := 0
+	for i := 0; i < 10; i++ {
+		if request("/items") == http.StatusServiceUnavailable {
+			shed++
+		}
+	}
+	if shed != 9 {
+		t.Errorf("Normal priority should be shed except the probes, shed %d", shed)
+	}
+	if code := request("/health"); code != 200 {
+		t.Errorf("Critical route should never be shed, got %d", code)
+	}
+}
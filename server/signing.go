@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijia/sweb/log"
+)
+
+const (
+	kSignatureScheme        = "SWEB-HMAC-SHA256"
+	kReplayCacheSweepPeriod = time.Minute
+)
+
+var (
+	// ErrInvalidSignature is returned if the request signature is malformed or mismatched.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrSignatureExpired is returned if the signing timestamp is out of the allowed skew.
+	ErrSignatureExpired = errors.New("signature expired")
+	// ErrReplayedRequest is returned if the nonce of the signed request was seen already.
+	ErrReplayedRequest = errors.New("replayed request")
+)
+
+// ReplayCache remembers the nonces of the signed requests, can be implemented by the shared storage for multiple
+// instances.
+type ReplayCache interface {
+	// Add records the nonce until it expires, returns false if the nonce was seen already
+	Add(nonce string, expires time.Time) (bool, error)
+}
+
+// SigningOptions defines the options of the RequestSigner.
+type SigningOptions struct {
+	// The shared secrets by the key ids, the client only needs its own key
+	Keys map[string][]byte
+	// The headers must be signed, default is "Host" and "Content-Type"
+	Headers []string
+	// The allowed clock skew of the signing timestamp, default is 5 minutes
+	MaxSkew time.Duration
+	// The nonce cache against the replay, default is in memory
+	Replay ReplayCache
+	// The max size of the body to digest, the larger ones are rejected with 413, default is 10MB
+	MaxBodySize int64
+}
+
+// RequestSigner signs and verifies the requests between the services by HMAC-SHA256. The signature covers the
+// method, path with query, the signed headers, the body digest, the timestamp and the nonce:
+//
+//	Authorization: SWEB-HMAC-SHA256 Credential=orders, Timestamp=1500000000, Nonce=..., SignedHeaders=host;content-type, Signature=...
+//
+// The server side uses it as an Authenticator of the AuthWare or by the NewSignatureWare, the principal subject is
+// the key id. The client side signs the outgoing requests by the Transport.
+//
+//	signer := server.NewRequestSigner(server.SigningOptions{Keys: map[string][]byte{"orders": secret}})
+//	client := &http.Client{Transport: signer.Transport("orders", nil)}
+type RequestSigner struct {
+	opt SigningOptions
+}
+
+// NewRequestSigner returns a new RequestSigner.
+func NewRequestSigner(opt SigningOptions) *RequestSigner {
+	if len(opt.Headers) == 0 {
+		opt.Headers = []string{"Host", "Content-Type"}
+	}
+	headers := make([]string, len(opt.Headers))
+	for i, name := range opt.Headers {
+		headers[i] = strings.ToLower(name)
+	}
+	opt.Headers = headers
+	if opt.MaxSkew <= 0 {
+		opt.MaxSkew = 5 * time.Minute
+	}
+	if opt.Replay == nil {
+		opt.Replay = NewMemoryReplayCache()
+	}
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = kBodyMaxSize
+	}
+	return &RequestSigner{opt}
+}
+
+// Sign signs the request with the key, the body would be read and restored.
+func (s *RequestSigner) Sign(r *http.Request, keyId string) error {
+	secret, ok := s.opt.Keys[keyId]
+	if !ok {
+		return fmt.Errorf("no signing key %q", keyId)
+	}
+	digest, err := bodyDigest(r, s.opt.MaxBodySize)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(buf)
+	signature := s.signature(secret, r, s.opt.Headers, digest, timestamp, nonce)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, Timestamp=%s, Nonce=%s, SignedHeaders=%s, Signature=%s",
+		kSignatureScheme, keyId, timestamp, nonce, strings.Join(s.opt.Headers, ";"), signature))
+	return nil
+}
+
+// Authenticate implements the Authenticator interface, the body would be read and restored.
+func (s *RequestSigner) Authenticate(r *http.Request) (*Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, kSignatureScheme+" ") {
+		return nil, nil
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(auth[len(kSignatureScheme)+1:], ",") {
+		if i := strings.Index(param, "="); i > 0 {
+			params[strings.TrimSpace(param[:i])] = strings.TrimSpace(param[i+1:])
+		}
+	}
+	keyId, nonce := params["Credential"], params["Nonce"]
+	secret, ok := s.opt.Keys[keyId]
+	if !ok || nonce == "" {
+		return nil, ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(params["Timestamp"], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	signed := time.Unix(unix, 0)
+	if skew := time.Since(signed); skew > s.opt.MaxSkew || skew < -s.opt.MaxSkew {
+		return nil, ErrSignatureExpired
+	}
+	headers := strings.Split(strings.ToLower(params["SignedHeaders"]), ";")
+	for _, name := range s.opt.Headers {
+		if !containsString(headers, name) {
+			log.Warnf("[RequestSigner] The header %q is not signed by %q", name, keyId)
+			return nil, ErrInvalidSignature
+		}
+	}
+	digest, err := bodyDigest(r, s.opt.MaxBodySize)
+	if err == ErrBodyTooLarge {
+		return nil, &AuthError{Status: http.StatusRequestEntityTooLarge, Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	expected := s.signature(secret, r, headers, digest, params["Timestamp"], nonce)
+	if !hmac.Equal([]byte(expected), []byte(params["Signature"])) {
+		return nil, ErrInvalidSignature
+	}
+	// only the valid signatures are remembered, so the cache cannot be filled by the forged ones
+	if fresh, err := s.opt.Replay.Add(keyId+":"+nonce, signed.Add(s.opt.MaxSkew)); err != nil {
+		return nil, err
+	} else if !fresh {
+		return nil, ErrReplayedRequest
+	}
+	return &Principal{Subject: keyId, Scheme: "hmac"}, nil
+}
+
+// Challenge implements the Authenticator interface.
+func (s *RequestSigner) Challenge() string {
+	return kSignatureScheme
+}
+
+// Transport returns the RoundTripper signing the outgoing requests with the key, the base is http.DefaultTransport
+// if nil.
+func (s *RequestSigner) Transport(keyId string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signingTransport{s, keyId, base}
+}
+
+func (s *RequestSigner) signature(secret []byte, r *http.Request, headers []string, digest, timestamp, nonce string) string {
+	var buf bytes.Buffer
+	buf.WriteString(kSignatureScheme + "\n" + timestamp + "\n" + nonce + "\n" + r.Method + "\n")
+	buf.WriteString(r.URL.EscapedPath())
+	if r.URL.RawQuery != "" {
+		buf.WriteString("?" + r.URL.RawQuery)
+	}
+	buf.WriteByte('\n')
+	for _, name := range headers {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		}
+		buf.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	buf.WriteString(digest)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(buf.Bytes())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSignatureWare returns the AuthWare which only accepts the signed requests.
+func NewSignatureWare(signer *RequestSigner) Middleware {
+	return NewAuthWare(AuthOptions{Authenticators: []Authenticator{signer}})
+}
+
+type signingTransport struct {
+	signer *RequestSigner
+	keyId  string
+	base   http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// the RoundTripper should not modify the request
+	signed := new(http.Request)
+	*signed = *r
+	signed.Header = make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		signed.Header[name] = append([]string(nil), values...)
+	}
+	if err := t.signer.Sign(signed, t.keyId); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// bodyDigest reads the body up to the limit, and restores it for the later reading.
+func bodyDigest(r *http.Request, limit int64) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(r.Body, limit+1)); err != nil {
+			return "", err
+		}
+		if int64(len(body)) > limit {
+			return "", ErrBodyTooLarge
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	digest := sha256.Sum256(body)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryReplayCache is the in memory ReplayCache, the expired nonces would be swept periodically.
+type MemoryReplayCache struct {
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryReplayCache returns a new in memory ReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		nonces:    make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Add implements the ReplayCache interface.
+func (c *MemoryReplayCache) Add(nonce string, expires time.Time) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > kReplayCacheSweepPeriod {
+		c.lastSweep = now
+		for key, t := range c.nonces {
+			if now.After(t) {
+				delete(c.nonces, key)
+			}
+		}
+	}
+	if t, ok := c.nonces[nonce]; ok && !now.After(t) {
+		return false, nil
+	}
+	c.nonces[nonce] = expires
+	return true, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type recordTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, r)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestRequestSigner(t *testing.T) {
+	keys := map[string][]byte{"orders": []byte("secret")}
+	srv := New(context.Background(), false)
+	srv.Middleware(NewSignatureWare(NewRequestSigner(SigningOptions{Keys: keys})))
+	srv.Post("/charge", "Charge", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		principal, _ := PrincipalFrom(ctx)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(principal.Subject + ":" + string(body)))
+		return ctx
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	recorder := &recordTransport{}
+	client := &http.Client{Transport: NewRequestSigner(SigningOptions{Keys: keys}).Transport("orders", recorder)}
+	resp, err := client.Post(ts.URL+"/charge?amount=10", "application/json", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != `orders:{"id":1}` {
+		t.Fatalf("Signed request should be accepted with the body, %d %q", resp.StatusCode, body)
+	}
+
+	signed := recorder.requests[0]
+	verify := func(verifier *RequestSigner, modify func(r *http.Request)) error {
+		r, _ := http.NewRequest("POST", signed.URL.String(), strings.NewReader(`{"id":1}`))
+		r.Host = signed.URL.Host
+		r.Header = signed.Header
+		if modify != nil {
+			modify(r)
+		}
+		_, err := verifier.Authenticate(r)
+		return err
+	}
+	verifier := NewRequestSigner(SigningOptions{Keys: keys})
+	unsigned := NewRequestSigner(SigningOptions{Keys: keys, Headers: []string{"Host", "X-Request-Id"}})
+	if err := verify(unsigned, nil); err != ErrInvalidSignature {
+		t.Errorf("Request without the required signed headers should be rejected, %v", err)
+	}
+	if err := verify(verifier, func(r *http.Request) { r.URL.RawQuery = "amount=1000" }); err != ErrInvalidSignature {
+		t.Errorf("Tampered query should be rejected, %v", err)
+	}
+	if err := verify(verifier, func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader(`{"id":2}`)) }); err != ErrInvalidSignature {
+		t.Errorf("Tampered body should be rejected, %v", err)
+	}
+	if err := verify(verifier, nil); err != nil {
+		t.Errorf("Signed request should be verified, %v", err)
+	}
+	if err := verify(verifier, nil); err != ErrReplayedRequest {
+		t.Errorf("Replayed request should be rejected, %v", err)
+	}
+	limited := NewRequestSigner(SigningOptions{Keys: keys, MaxBodySize: 4})
+	if err, ok := verify(limited, nil).(*AuthError); !ok || err.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Body larger than the limit should be rejected, %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/charge", strings.NewReader(strings.Repeat("x", kBodyMaxSize+1)))
+	r.Header.Set("Authorization", signed.Header.Get("Authorization"))
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized signed request should get 413, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/charge", nil)
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != kSignatureScheme {
+		t.Errorf("Unsigned request should be rejected with the challenge, %d %v", w.Code, w.Header())
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	now := cache.lastSweep
+	if fresh, _ := cache.Add("a", now.Add(-1)); !fresh {
+		t.Errorf("New nonce should be fresh")
+	}
+	if fresh, _ := cache.Add("a", now.Add(kReplayCacheSweepPeriod)); !fresh {
+		t.Errorf("Expired nonce can be added again")
+	}
+	if fresh, _ := cache.Add("a", now.Add(kReplayCacheSweepPeriod)); fresh {
+		t.Errorf("Seen nonce should not be fresh")
+	}
+}
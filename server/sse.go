@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kSSEBufferSize = 16
+)
+
+// ErrSSEClosed is returned when sending to a closed event stream.
+var ErrSSEClosed = errors.New("server: the event stream is closed")
+
+// SSEStream is a Server-Sent Events stream on the ResponseWriter, the stream would be terminated when the client
+// disconnects, the context is done or the stream is closed.
+type SSEStream struct {
+	w           ResponseWriter
+	lastEventId string
+	lock        sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// SSE starts the event stream on the response, the Last-Event-ID of the request can be used for resuming. The stream
+// would be terminated with the request context after the handler returns.
+//
+//	stream, err := server.SSE(ctx, w)
+//	if err != nil {
+//		...
+//	}
+//	broker.Subscribe("news", stream)
+func SSE(ctx context.Context, w http.ResponseWriter) (*SSEStream, error) {
+	rw, ok := w.(ResponseWriter)
+	if !ok {
+		return nil, fmt.Errorf("server: the event stream needs the server.ResponseWriter")
+	}
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream; charset=UTF-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	rw.Flush()
+
+	stream := &SSEStream{
+		w:    rw,
+		done: make(chan struct{}),
+	}
+	if r, ok := ctx.Value(kRequestKey).(*http.Request); ok {
+		stream.lastEventId = r.Header.Get("Last-Event-ID")
+	}
+	var closeNotify <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeNotify = notifier.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closeNotify:
+		case <-ctx.Done():
+		case <-stream.done:
+		}
+		stream.Close()
+	}()
+	return stream, nil
+}
+
+// SSEHandler adapts the event stream handler to the Handler, the stream would be closed when the handler returns.
+func SSEHandler(handle func(ctx context.Context, stream *SSEStream, r *http.Request) context.Context) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		stream, err := SSE(ctx, w)
+		if err != nil {
+			WriteProblem(w, http.StatusInternalServerError, err.Error())
+			return ctx
+		}
+		defer stream.Close()
+		return handle(ctx, stream, r)
+	}
+}
+
+// LastEventId returns the Last-Event-ID sent by the reconnecting client.
+func (s *SSEStream) LastEventId() string {
+	return s.lastEventId
+}
+
+// Done returns a channel which would be closed when the stream is terminated.
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close terminates the stream before the request is done, it waits for the writing event so that nothing is written
+// after it returns.
+func (s *SSEStream) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.terminate()
+}
+
+// terminate closes the done channel, should be called with the lock.
+func (s *SSEStream) terminate() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Send sends an event, the event and id can be empty. The data of string or []byte would be sent as is, other data
+// would be marshaled as json.
+func (s *SSEStream) Send(event string, id string, data interface{}) error {
+	var payload []byte
+	switch v := data.(type) {
+	case string:
+		payload = []byte(v)
+	case []byte:
+		payload = v
+	default:
+		var err error
+		if payload, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", sseField(id))
+	}
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", sseField(event))
+	}
+	for _, line := range strings.Split(strings.Replace(string(payload), "\r\n", "\n", -1), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// Comment sends a comment line which would be ignored by the client.
+func (s *SSEStream) Comment(text string) error {
+	return s.write([]byte(": " + sseField(text) + "\n\n"))
+}
+
+// Retry tells the client the reconnection time.
+func (s *SSEStream) Retry(d time.Duration) error {
+	return s.write([]byte("retry: " + strconv.FormatInt(int64(d/time.Millisecond), 10) + "\n\n"))
+}
+
+// Heartbeat sends the heartbeat comments in the interval until the stream is terminated, it is useful to keep the
+// connection alive through the proxies.
+func (s *SSEStream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Comment("heartbeat"); err != nil {
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *SSEStream) write(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.done:
+		return ErrSSEClosed
+	default:
+	}
+	if _, err := s.w.Write(data); err != nil {
+		s.terminate()
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+func sseField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// SSEBroker fans out the events of the topics to the subscribed streams, and keeps the recent events of each topic
+// so that the reconnecting clients can resume from the Last-Event-ID.
+type SSEBroker struct {
+	lock        sync.RWMutex
+	seq         int64
+	historySize int
+	topics      map[string]map[*sseSubscriber]bool
+	history     map[string][]sseEvent
+}
+
+type sseEvent struct {
+	id    int64
+	event string
+	data  interface{}
+}
+
+type sseSubscriber struct {
+	events chan sseEvent
+	gone   chan struct{}
+}
+
+// NewSSEBroker returns a new broker keeping historySize recent events for each topic.
+func NewSSEBroker(historySize int) *SSEBroker {
+	return &SSEBroker{
+		historySize: historySize,
+		topics:      make(map[string]map[*sseSubscriber]bool),
+		history:     make(map[string][]sseEvent),
+	}
+}
+
+// Publish sends the event to all the subscribers of the topic, returns the event id. The slow subscribers which
+// cannot keep up would be disconnected.
+func (b *SSEBroker) Publish(topic string, event string, data interface{}) int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.seq++
+	e := sseEvent{b.seq, event, data}
+	if b.historySize > 0 {
+		history := append(b.history[topic], e)
+		if len(history) > b.historySize {
+			history = history[len(history)-b.historySize:]
+		}
+		b.history[topic] = history
+	}
+	for sub := range b.topics[topic] {
+		select {
+		case sub.events <- e:
+		default:
+			delete(b.topics[topic], sub)
+			close(sub.gone)
+		}
+	}
+	return e.id
+}
+
+// Subscribe streams the events of the topic to the stream until it is terminated, the missed events after the
+// Last-Event-ID would be replayed first.
+func (b *SSEBroker) Subscribe(topic string, stream *SSEStream) error {
+	sub := &sseSubscriber{
+		events: make(chan sseEvent, kSSEBufferSize),
+		gone:   make(chan struct{}),
+	}
+	b.lock.Lock()
+	var missed []sseEvent
+	if lastId, err := strconv.ParseInt(stream.LastEventId(), 10, 64); err == nil {
+		for _, e := range b.history[topic] {
+			if e.id > lastId {
+				missed = append(missed, e)
+			}
+		}
+	}
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make(map[*sseSubscriber]bool)
+	}
+	b.topics[topic][sub] = true
+	b.lock.Unlock()
+
+	defer b.unsubscribe(topic, sub)
+	for _, e := range missed {
+		if err := stream.Send(e.event, strconv.FormatInt(e.id, 10), e.data); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e := <-sub.events:
+			if err := stream.Send(e.event, strconv.FormatInt(e.id, 10), e.data); err != nil {
+				return err
+			}
+		case <-sub.gone:
+			stream.Close()
+			return ErrSSEClosed
+		case <-stream.Done():
+			return nil
+		}
+	}
+}
+
+// Subscribers returns the count of the subscribers of the topic.
+func (b *SSEBroker) Subscribers(topic string) int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.topics[topic])
+}
+
+func (b *SSEBroker) unsubscribe(topic string, sub *sseSubscriber) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if subs, ok := b.topics[topic]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+}
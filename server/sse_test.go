@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type lockedRecorder struct {
+	*httptest.ResponseRecorder
+	lock sync.Mutex
+}
+
+func (w *lockedRecorder) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.ResponseRecorder.Write(b)
+}
+
+func (w *lockedRecorder) contains(s string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return bytes.Contains(w.Body.Bytes(), []byte(s))
+}
+
+func TestSSE(t *testing.T) {
+	broker := NewSSEBroker(10)
+	broker.Publish("news", "", "missed")
+	broker.Publish("news", "update", "hello\nworld")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := New(ctx, false)
+	errs := make(chan error, 1)
+	srv.Get("/events", "Events", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		stream, err := SSE(ctx, w)
+		if err != nil {
+			errs <- err
+			return ctx
+		}
+		stream.Send("", "", map[string]int{"a": 1})
+		broker.Subscribe("news", stream)
+		return ctx
+	})
+
+	w := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r, _ := http.NewRequest("GET", "/events", nil)
+	r.Header.Set("Last-Event-ID", "1")
+	done := make(chan struct{})
+	go func() {
+		srv.ServeHTTP(w, r)
+		close(done)
+	}()
+	for i := 0; i < 100 && broker.Subscribers("news") == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Publish("news", "", "live")
+	for i := 0; i < 100 && !w.contains("live"); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case err := <-errs:
+		t.Fatalf("Cannot start the event stream, %s", err)
+	case <-time.After(time.Second):
+		t.Fatalf("The event stream should be terminated when the context is done")
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Wrong content type, got=%s", ct)
+	}
+	expected := "data: {\"a\":1}\n\nid: 2\nevent: update\ndata: hello\ndata: world\n\nid: 3\ndata: live\n\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Wrong event stream, expected=%q, got=%q", expected, body)
+	}
+	if broker.Subscribers("news") != 0 {
+		t.Errorf("Should unsubscribe the terminated stream")
+	}
+}
+
+func TestSSEDone(t *testing.T) {
+	var stream *SSEStream
+	srv := New(context.Background(), false)
+	srv.Get("/events", "Events", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		stream, _ = SSE(ctx, w)
+		stream.Heartbeat(time.Millisecond)
+		return ctx
+	})
+	r, _ := http.NewRequest("GET", "/events", nil)
+	srv.ServeHTTP(&lockedRecorder{ResponseRecorder: httptest.NewRecorder()}, r)
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Errorf("The stream should be terminated when the request is done")
+	}
+}
+
+func TestSSEHandler(t *testing.T) {
+	var stream *SSEStream
+	srv := New(context.Background(), false)
+	srv.Get("/events", "Events", SSEHandler(func(ctx context.Context, s *SSEStream, r *http.Request) context.Context {
+		stream = s
+		s.Heartbeat(time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		return ctx
+	}))
+	w := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r, _ := http.NewRequest("GET", "/events", nil)
+	srv.ServeHTTP(w, r)
+
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatalf("The stream should be closed when the handler returns")
+	}
+	if !w.contains(": heartbeat") {
+		t.Errorf("Should send the heartbeats")
+	}
+	w.lock.Lock()
+	size := w.Body.Len()
+	w.lock.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	if err := stream.Comment("late"); err != ErrSSEClosed || w.Body.Len() != size {
+		t.Errorf("Nothing should be written after the handler returns, %v", err)
+	}
+}
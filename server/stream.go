@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/mijia/sweb/log"
+	"github.com/mijia/sweb/render"
+	"golang.org/x/net/context"
+)
+
+// ResourceIterator can be returned by the ResourceHandler to stream the large result sets, Next returns io.EOF when
+// there is no more items. The iterator would be closed after streaming if it implements the io.Closer.
+type ResourceIterator interface {
+	Next() (interface{}, error)
+}
+
+// isStreamData tells if the resource data is a receiving channel or a ResourceIterator.
+func isStreamData(v interface{}) bool {
+	if _, ok := v.(ResourceIterator); ok {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	return value.Kind() == reflect.Chan && value.Type().ChanDir()&reflect.RecvDir != 0
+}
+
+// streamResource streams the items from the channel or iterator as a json array, or NDJSON if the client accepts
+// "application/x-ndjson". The items are pulled only after the previous one is written, and the context would be
+// cancelled when the client disconnects so that the producers can stop.
+func streamResource(ctx context.Context, cancel context.CancelFunc, w http.ResponseWriter, r *http.Request,
+	status int, v interface{}) {
+	if closer, ok := v.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeNotify := notifier.CloseNotify()
+		go func() {
+			select {
+			case <-closeNotify:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	stream := render.JsonStream(w, status)
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		stream = render.NDJsonStream(w, status)
+	}
+	fields := r.URL.Query().Get(kFieldsQuery)
+	next := streamNext(ctx, v)
+	for {
+		item, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warnf("Restful stream %q stopped after %d items, %s", r.URL.Path, stream.Count(), err)
+			if stream.Count() == 0 && ctx.Err() == nil {
+				WriteProblem(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		if fields != "" {
+			if item, err = ShapeFields(item, fields); err != nil {
+				if stream.Count() == 0 {
+					WriteProblem(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				log.Warnf("Restful stream %q stopped after %d items, %s", r.URL.Path, stream.Count(), err)
+				return
+			}
+		}
+		if err := stream.Encode(item); err != nil {
+			cancel()
+			return
+		}
+	}
+	stream.Close()
+}
+
+// streamNext returns the function pulling the next item, io.EOF would be returned at the end.
+func streamNext(ctx context.Context, v interface{}) func() (interface{}, error) {
+	if iter, ok := v.(ResourceIterator); ok {
+		return func() (interface{}, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return iter.Next()
+		}
+	}
+	value := reflect.ValueOf(v)
+	if value.IsNil() {
+		// a nil channel would block forever, just take it as empty
+		return func() (interface{}, error) {
+			return nil, io.EOF
+		}
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: value},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	return func() (interface{}, error) {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return nil, ctx.Err()
+		}
+		if !ok {
+			return nil, io.EOF
+		}
+		return item.Interface(), nil
+	}
+}
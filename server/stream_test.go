@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type sItem struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type sIterator struct {
+	n      int
+	closed bool
+}
+
+func (it *sIterator) Next() (interface{}, error) {
+	if it.n >= 2 {
+		return nil, io.EOF
+	}
+	it.n++
+	return sItem{it.n, "iter"}, nil
+}
+
+func (it *sIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.writes > 3 {
+		return 0, errors.New("client gone")
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestRestfulStream(t *testing.T) {
+	produced := make(chan int, 1)
+	iter := &sIterator{}
+	srv := New(context.Background(), false)
+	srv.Get("/chan", "Chan", srv.defaultRestfulAdapter(func(ctx context.Context, r *http.Request) (int, interface{}) {
+		items := make(chan sItem)
+		go func() {
+			defer close(items)
+			for i := 1; i <= 3; i++ {
+				items <- sItem{i, "chan"}
+			}
+		}()
+		return http.StatusOK, items
+	}))
+	srv.Get("/iter", "Iter", srv.defaultRestfulAdapter(func(ctx context.Context, r *http.Request) (int, interface{}) {
+		return http.StatusOK, iter
+	}))
+	srv.Get("/nil", "Nil", srv.defaultRestfulAdapter(func(ctx context.Context, r *http.Request) (int, interface{}) {
+		var items chan sItem
+		return http.StatusOK, items
+	}))
+	srv.Get("/endless", "Endless", srv.defaultRestfulAdapter(func(ctx context.Context, r *http.Request) (int, interface{}) {
+		items := make(chan int)
+		go func() {
+			i := 0
+			for {
+				select {
+				case items <- i:
+					i++
+				case <-ctx.Done():
+					produced <- i
+					return
+				}
+			}
+		}()
+		return http.StatusOK, items
+	}))
+
+	cases := []struct {
+		path     string
+		accept   string
+		expected string
+	}{
+		{"/chan", "", `[{"id":1,"name":"chan"},{"id":2,"name":"chan"},{"id":3,"name":"chan"}]` + "\n"},
+		{"/chan?fields=id", "application/x-ndjson", "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"},
+		{"/iter", "", `[{"id":1,"name":"iter"},{"id":2,"name":"iter"}]` + "\n"},
+		{"/nil", "", "[]\n"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", c.path, nil)
+		r.Header.Set("Accept", c.accept)
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != c.expected {
+			t.Errorf("Wrong stream for %s, expected=%q, got=%d %q", c.path, c.expected, w.Code, w.Body)
+		}
+	}
+	if !iter.closed {
+		t.Errorf("Should close the iterator after streaming")
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/chan?fields=unknown", nil)
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Should reject the unknown fields, got=%d", w.Code)
+	}
+
+	r, _ = http.NewRequest("GET", "/endless", nil)
+	srv.ServeHTTP(&failingWriter{ResponseRecorder: httptest.NewRecorder()}, r)
+	select {
+	case n := <-produced:
+		if n > 5 {
+			t.Errorf("Should stop producing soon after the client is gone, produced=%d", n)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Should cancel the producer when the client is gone")
+	}
+}
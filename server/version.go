@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kVersionKey    = "inter_ctx_key_api_version"
+	kVersionHeader = "X-API-Version"
+	kVersionPrefix = "/v%d"
+)
+
+// VersionOptions defines how the API version is selected for the unprefixed routes.
+type VersionOptions struct {
+	// The vendor of the media type like "application/vnd.{vendor}.v2+json", any vendor would be accepted if empty
+	Vendor string
+
+	// The custom request header for the version, default is "X-API-Version"
+	Header string
+
+	// The url prefix format for the version, default is "/v%d"
+	Prefix string
+}
+
+// VersionMuxer registers the routes and resources for an API version. Each route is registered with the url prefix,
+// e.g. "/v2/users", and named as "Name@v2" for reversing; the unprefixed route would select the version by the
+// Accept or the custom header, and fallback to the latest compatible version.
+type VersionMuxer struct {
+	srv         *Server
+	version     int
+	wares       []Middleware
+	deprecation string
+	sunset      string
+}
+
+// VersionOptions sets the options for the API versioning.
+func (s *Server) VersionOptions(opt VersionOptions) {
+	if opt.Header == "" {
+		opt.Header = kVersionHeader
+	}
+	if opt.Prefix == "" {
+		opt.Prefix = kVersionPrefix
+	}
+	s.versionOpt = opt
+	s.versionAccept = regexp.MustCompile(`vnd\.` + regexp.QuoteMeta(opt.Vendor) + `\.?v(\d+)\b`)
+	if opt.Vendor == "" {
+		s.versionAccept = regexp.MustCompile(`vnd\.[^;,\s]*?\.?v(\d+)\b`)
+	}
+}
+
+// Version returns the muxer to register the routes for the API version.
+func (s *Server) Version(version int) *VersionMuxer {
+	if s.versionAccept == nil {
+		s.VersionOptions(VersionOptions{})
+	}
+	if s.versionMuxers == nil {
+		s.versionMuxers = make(map[int]*VersionMuxer)
+	}
+	if v, ok := s.versionMuxers[version]; ok {
+		return v
+	}
+	v := &VersionMuxer{srv: s, version: version}
+	s.versionMuxers[version] = v
+	return v
+}
+
+// APIVersion returns the API version of the matched route, 0 for the unversioned routes.
+func APIVersion(ctx context.Context) int {
+	if version, ok := ctx.Value(kVersionKey).(int); ok {
+		return version
+	}
+	return 0
+}
+
+// VersionedName returns the routing name of the route for the API version, e.g. "Hello@v2".
+func VersionedName(name string, version int) string {
+	return fmt.Sprintf("%s@v%d", name, version)
+}
+
+// Deprecate marks the version as deprecated, the responses would have the "Deprecation" header, and the "Sunset"
+// header if the sunset time is given.
+func (v *VersionMuxer) Deprecate(deprecatedAt time.Time, sunset time.Time) {
+	v.deprecation = "true"
+	if !deprecatedAt.IsZero() {
+		v.deprecation = fmt.Sprintf("@%d", deprecatedAt.Unix())
+	}
+	v.sunset = ""
+	if !sunset.IsZero() {
+		v.sunset = sunset.UTC().Format(http.TimeFormat)
+	}
+}
+
+// Middleware registers a middleware only for the routes of this version, would run after the server middlewares.
+func (v *VersionMuxer) Middleware(ware Middleware) {
+	v.wares = append(v.wares, ware)
+}
+
+// Handle registers the handler for the version with the prefixed path and the versioned name, and the unprefixed
+// path with the name selecting the version. The version of the unprefixed path is selected while routing, so the
+// RouteName seen by the middlewares is always the versioned one like "Name@v2".
+func (v *VersionMuxer) Handle(method, path, name string, handle Handler) {
+	s := v.srv
+	core := func(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+		return handle(ctx, w, r)
+	}
+	onion := buildOnion(append(v.wares, MiddleFn(core)))
+	versioned := func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		w.Header().Set(s.versionOpt.Header, strconv.Itoa(v.version))
+		if v.deprecation != "" {
+			w.Header().Set("Deprecation", v.deprecation)
+		}
+		if v.sunset != "" {
+			w.Header().Set("Sunset", v.sunset)
+		}
+		return onion.ServeHTTP(context.WithValue(ctx, kVersionKey, v.version), w, r)
+	}
+	prefix := fmt.Sprintf(s.versionOpt.Prefix, v.version)
+	s.Handle(method, prefix+path, VersionedName(name, v.version), versioned)
+	route := s.routes[len(s.routes)-1]
+	route.version, route.handle = v.version, versioned
+
+	key := method + " " + path
+	if s.versionedRoutes == nil {
+		s.versionedRoutes = make(map[string]*routeInfo)
+	}
+	dispatcher, ok := s.versionedRoutes[key]
+	if !ok {
+		s.Handle(method, path, name, s.versionDispatcher)
+		dispatcher = s.routes[len(s.routes)-1]
+		dispatcher.versions = make(map[int]*routeInfo)
+		s.versionedRoutes[key] = dispatcher
+	}
+	dispatcher.versions[v.version] = route
+}
+
+// Get will register a 'GET' request handler for the version.
+func (v *VersionMuxer) Get(path string, name string, handle Handler) {
+	v.Handle("GET", path, name, handle)
+}
+
+// Post will register a 'POST' request handler for the version.
+func (v *VersionMuxer) Post(path string, name string, handle Handler) {
+	v.Handle("POST", path, name, handle)
+}
+
+// Put will register a 'PUT' request handler for the version.
+func (v *VersionMuxer) Put(path string, name string, handle Handler) {
+	v.Handle("PUT", path, name, handle)
+}
+
+// Patch will register a 'PATCH' request handler for the version.
+func (v *VersionMuxer) Patch(path string, name string, handle Handler) {
+	v.Handle("PATCH", path, name, handle)
+}
+
+// Delete will register a 'DELETE' request handler for the version.
+func (v *VersionMuxer) Delete(path string, name string, handle Handler) {
+	v.Handle("DELETE", path, name, handle)
+}
+
+// Head will register a 'HEAD' request handler for the version.
+func (v *VersionMuxer) Head(path string, name string, handle Handler) {
+	v.Handle("HEAD", path, name, handle)
+}
+
+// AddRestfulResource will register the resource for the version, the routing names would be like "Get_users@v2".
+func (v *VersionMuxer) AddRestfulResource(path string, name string, resource Resource) {
+	v.srv.addRestfulResource(v, path, name, resource)
+}
+
+// Reverse would reverse the named routes of this version.
+func (v *VersionMuxer) Reverse(name string, params ...interface{}) string {
+	return v.srv.Reverse(VersionedName(name, v.version), params...)
+}
+
+// selectVersion selects the versioned route for the unprefixed route while routing, by the requested version or the
+// latest compatible version which is the latest one not newer than the requested, so that the middlewares would see
+// the versioned route name. The unprefixed route is returned if the requested version is not supported.
+func (s *Server) selectVersion(route *routeInfo, r *http.Request) *routeInfo {
+	if len(route.versions) == 0 {
+		return route
+	}
+	requested, ok := s.requestedVersion(r)
+	var selected *routeInfo
+	for version, versioned := range route.versions {
+		if (!ok || version <= requested) && (selected == nil || version > selected.version) {
+			selected = versioned
+		}
+	}
+	if selected == nil {
+		return route
+	}
+	return selected
+}
+
+// versionDispatcher serves the unprefixed route by the handler of the versioned route selected while routing.
+func (s *Server) versionDispatcher(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", s.versionOpt.Header)
+	if route, ok := ctx.Value(kRouteKey).(*routeInfo); ok && route.handle != nil {
+		return route.handle(ctx, w, r)
+	}
+	requested, _ := s.requestedVersion(r)
+	WriteProblem(w, http.StatusNotAcceptable, fmt.Sprintf("API version %d is not supported", requested))
+	return ctx
+}
+
+func (s *Server) requestedVersion(r *http.Request) (int, bool) {
+	if value := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(s.versionOpt.Header)), "v"); value != "" {
+		if version, err := strconv.Atoi(value); err == nil {
+			return version, true
+		}
+	}
+	if matches := s.versionAccept.FindStringSubmatch(r.Header.Get("Accept")); len(matches) > 1 {
+		if version, err := strconv.Atoi(matches[1]); err == nil {
+			return version, true
+		}
+	}
+	return 0, false
+}
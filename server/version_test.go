@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func versionHandle(tag string) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		fmt.Fprintf(w, "%s:%d", tag, APIVersion(ctx))
+		return ctx
+	}
+}
+
+func TestVersion(t *testing.T) {
+	srv := New(context.Background(), false)
+	srv.VersionOptions(VersionOptions{Vendor: "sweb"})
+	var routeName string
+	srv.Middleware(MiddleFn(func(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+		routeName = RouteName(ctx)
+		return next(ctx, w, r)
+	}))
+	v1, v2, v3 := srv.Version(1), srv.Version(2), srv.Version(3)
+	v1.Get("/users/:id", "User", versionHandle("user"))
+	v2.Get("/users/:id", "User", versionHandle("user"))
+	v3.Get("/items", "Items", versionHandle("items"))
+	v1.Deprecate(time.Unix(1500000000, 0), time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	if urlPath := v2.Reverse("User", 1); urlPath != srv.Reverse("User@v2", 1) || srv.namedRoutes["User@v2"] != "/v2/users/:id" {
+		t.Errorf("Wrong reverse for the versioned route, got=%s", urlPath)
+	}
+
+	cases := []struct {
+		path     string
+		header   string
+		accept   string
+		status   int
+		expected string
+		route    string
+	}{
+		{"/v1/users/1", "", "", http.StatusOK, "user:1", "User@v1"},
+		{"/v2/users/1", "", "", http.StatusOK, "user:2", "User@v2"},
+		{"/users/1", "", "", http.StatusOK, "user:2", "User@v2"},
+		{"/users/1", "1", "", http.StatusOK, "user:1", "User@v1"},
+		{"/users/1", "", "application/vnd.sweb.v1+json", http.StatusOK, "user:1", "User@v1"},
+		{"/users/1", "", "application/vnd.sweb.v3+json", http.StatusOK, "user:2", "User@v2"},
+		{"/items", "2", "", http.StatusNotAcceptable, "", "Items"},
+		{"/items", "", "", http.StatusOK, "items:3", "Items@v3"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", c.path, nil)
+		if c.header != "" {
+			r.Header.Set(kVersionHeader, c.header)
+		}
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		srv.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("Wrong status for %s, expected=%d, got=%d", c.path, c.status, w.Code)
+			continue
+		}
+		if c.expected != "" && w.Body.String() != c.expected {
+			t.Errorf("Wrong body for %s, expected=%s, got=%s", c.path, c.expected, w.Body)
+		}
+		if routeName != c.route {
+			t.Errorf("Middlewares should see the selected route for %s, expected=%s, got=%s", c.path, c.route, routeName)
+		}
+		if c.expected == "user:1" {
+			if w.Header().Get("Deprecation") != "@1500000000" || w.Header().Get("Sunset") != "Tue, 01 Jan 2030 00:00:00 GMT" {
+				t.Errorf("Should have the deprecation headers for v1, %v", w.Header())
+			}
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mijia/sweb/log"
+	"golang.org/x/net/context"
+)
+
+// The message types of the WebSocket
+const (
+	WebSocketText   = 1
+	WebSocketBinary = 2
+)
+
+// The close codes of the WebSocket
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupported     = 1003
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseTooBig          = 1009
+	WebSocketCloseInternalError   = 1011
+)
+
+const (
+	kWebSocketGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	kWebSocketMaxMessage   = 1 << 20
+	kWebSocketPingInterval = 30 * time.Second
+	kWebSocketCloseTimeout = 5 * time.Second
+
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// ErrWebSocketClosed is returned when writing to a closed WebSocket connection.
+var ErrWebSocketClosed = errors.New("server: the websocket connection is closed")
+
+// WebSocketCloseError is returned by ReadMessage when the connection is closed by the peer or a protocol violation.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("server: websocket closed, code=%d, reason=%q", e.Code, e.Reason)
+}
+
+// WebSocketHandler is the function type to serve the WebSocket connection, the connection would be closed after
+// the handler returns.
+type WebSocketHandler func(ctx context.Context, conn *WebSocketConn)
+
+// WebSocketOptions defines the options for the WebSocket endpoint.
+type WebSocketOptions struct {
+	// Allowed origins like "https://example.com", only the same host origin would be allowed if empty
+	AllowedOrigins []string
+
+	// Custom origin check, would override the AllowedOrigins
+	CheckOrigin func(r *http.Request) bool
+
+	// Max size of a message, default is 1MB
+	MaxMessageSize int64
+
+	// Interval of the keepalive pings, default is 30s; the connection would be closed if nothing is received
+	// from the client in two intervals.
+	PingInterval time.Duration
+
+	// Supported subprotocols, the first one requested by the client would be selected
+	Subprotocols []string
+}
+
+// WebSocket registers the RFC 6455 WebSocket endpoint at the path with given routing name, the upgrade request
+// runs through the middlewares and the response status would be 101 after the handshake.
+func (s *Server) WebSocket(path string, name string, handle WebSocketHandler, opts ...WebSocketOptions) {
+	var opt WebSocketOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.MaxMessageSize <= 0 {
+		opt.MaxMessageSize = kWebSocketMaxMessage
+	}
+	if opt.PingInterval <= 0 {
+		opt.PingInterval = kWebSocketPingInterval
+	}
+	s.Get(path, name, func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		conn, err := upgradeWebSocket(w, r, opt)
+		if err != nil {
+			log.Warnf("[WebSocket] Upgrade %q failed, %s", r.URL.Path, err)
+			return ctx
+		}
+		go conn.keepalive()
+		defer conn.Close(WebSocketCloseNormal, "")
+		handle(ctx, conn)
+		return ctx
+	})
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, opt WebSocketOptions) (*WebSocketConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		WriteProblem(w, http.StatusBadRequest, "Not a websocket handshake")
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		WriteProblem(w, http.StatusUpgradeRequired, "Unsupported websocket version")
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		WriteProblem(w, http.StatusBadRequest, "Invalid Sec-WebSocket-Key")
+		return nil, fmt.Errorf("invalid websocket key")
+	}
+	if !checkWebSocketOrigin(r, opt) {
+		WriteProblem(w, http.StatusForbidden, "Origin is not allowed")
+		return nil, fmt.Errorf("origin %q is not allowed", r.Header.Get("Origin"))
+	}
+	subprotocol := ""
+	for _, requested := range headerValues(r.Header, "Sec-WebSocket-Protocol") {
+		for _, supported := range opt.Subprotocols {
+			if subprotocol == "" && requested == supported {
+				subprotocol = supported
+			}
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		WriteProblem(w, http.StatusInternalServerError, "The ResponseWriter doesn't support the Hijacker interface")
+		return nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	netConn, brw, err := hijacker.Hijack()
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + kWebSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n"
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	netConn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(netConn, response+"\r\n"); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return &WebSocketConn{
+		Subprotocol:  subprotocol,
+		conn:         netConn,
+		reader:       brw.Reader,
+		maxSize:      opt.MaxMessageSize,
+		pingInterval: opt.PingInterval,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+func checkWebSocketOrigin(r *http.Request, opt WebSocketOptions) bool {
+	if opt.CheckOrigin != nil {
+		return opt.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser client
+		return true
+	}
+	for _, allowed := range opt.AllowedOrigins {
+		if strings.EqualFold(origin, allowed) || allowed == "*" {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && len(opt.AllowedOrigins) == 0 && strings.EqualFold(u.Host, r.Host)
+}
+
+// WebSocketConn is the server side WebSocket connection, ReadMessage should be called from one goroutine, the
+// writes are safe to be called concurrently.
+type WebSocketConn struct {
+	// Subprotocol negotiated in the handshake
+	Subprotocol string
+
+	conn         net.Conn
+	reader       *bufio.Reader
+	maxSize      int64
+	pingInterval time.Duration
+	writeLock    sync.Mutex
+	closeSent    bool
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+// ReadMessage reads a complete message, the pings would be answered and the close handshake would be finished
+// inside, a *WebSocketCloseError would be returned when the connection is closed.
+func (c *WebSocketConn) ReadMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if closeErr, ok := err.(*WebSocketCloseError); ok {
+				return 0, nil, c.fail(closeErr.Code, closeErr.Reason)
+			}
+			c.closeConn()
+			return 0, nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			code := closeErr.Code
+			if code == WebSocketCloseNoStatus {
+				code = WebSocketCloseNormal
+			}
+			c.writeClose(code, "")
+			c.closeConn()
+			return 0, nil, closeErr
+		case WebSocketText, WebSocketBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(WebSocketCloseProtocolError, "expected a continuation frame")
+			}
+			messageType = opcode
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(WebSocketCloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(WebSocketCloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message)+len(payload)) > c.maxSize {
+			return 0, nil, c.fail(WebSocketCloseTooBig, "message is too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == WebSocketText && !utf8.Valid(message) {
+				return 0, nil, c.fail(WebSocketCloseInvalidPayload, "invalid utf-8 text")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// ReadJson reads a message and unmarshals it as json.
+func (c *WebSocketConn) ReadJson(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage writes a text or binary message.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != WebSocketText && messageType != WebSocketBinary {
+		return fmt.Errorf("server: unknown websocket message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteJson marshals the value as json and writes it as a text message.
+func (c *WebSocketConn) WriteJson(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(WebSocketText, data)
+}
+
+// Close starts the close handshake with the code and reason, and closes the connection after the peer responds
+// or timeout.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err != nil {
+		c.closeConn()
+		return err
+	}
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	// wait for the close frame from the peer
+	c.conn.SetReadDeadline(time.Now().Add(kWebSocketCloseTimeout))
+	for {
+		_, opcode, _, err := c.readFrame()
+		if err != nil || opcode == opClose {
+			break
+		}
+	}
+	c.closeConn()
+	return nil
+}
+
+// Done returns a channel which would be closed when the connection is closed.
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.closeConn()
+	return &WebSocketCloseError{code, reason}
+}
+
+func (c *WebSocketConn) keepalive() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *WebSocketConn) closeConn() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *WebSocketConn) writeClose(code int, reason string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrameLocked(opClose, append(payload, reason...))
+}
+
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes an unmasked final frame as the server.
+func (c *WebSocketConn) writeFrameLocked(opcode int, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.pingInterval))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads a frame from the client, the client frames must be masked.
+func (c *WebSocketConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &WebSocketCloseError{WebSocketCloseProtocolError, "reserved bits are set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &WebSocketCloseError{WebSocketCloseProtocolError, "client frames must be masked"}
+	}
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, &WebSocketCloseError{WebSocketCloseProtocolError, "invalid control frame"}
+	}
+	if length < 0 || length > c.maxSize {
+		return false, 0, nil, &WebSocketCloseError{WebSocketCloseTooBig, "message is too big"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range headerValues(header, key) {
+		if strings.EqualFold(value, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerValues splits the comma separated header values.
+func headerValues(header http.Header, key string) []string {
+	var values []string
+	for _, line := range header[http.CanonicalHeaderKey(key)] {
+		for _, value := range strings.Split(line, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func wsWriteFrame(conn net.Conn, opcode byte, payload []byte) {
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	conn.Write(frame)
+}
+
+func wsReadFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatalf("Cannot read the frame, %s", err)
+	}
+	payload := make([]byte, header[1]&0x7F)
+	io.ReadFull(reader, payload)
+	return header[0] & 0x0F, payload
+}
+
+func TestWebSocket(t *testing.T) {
+	statuses := make(chan int, 1)
+	srv := New(context.Background(), false)
+	srv.Middleware(MiddleFn(func(ctx context.Context, w http.ResponseWriter, r *http.Request, next Handler) context.Context {
+		newCtx := next(ctx, w, r)
+		statuses <- w.(ResponseWriter).Status()
+		return newCtx
+	}))
+	srv.WebSocket("/ws", "WebSocket", func(ctx context.Context, conn *WebSocketConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, append([]byte("echo: "), data...))
+		}
+	}, WebSocketOptions{MaxMessageSize: 16, Subprotocols: []string{"chat"}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	r, _ := http.NewRequest("GET", ts.URL+"/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Origin", "http://evil.example.com")
+	if res, err := http.DefaultClient.Do(r); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Should reject the cross origin request")
+	}
+	<-statuses
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Cannot dial the test server, %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: " + strings.TrimPrefix(ts.URL, "http://") + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: x, chat\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, r)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Should switch the protocol, %v", err)
+	}
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Wrong Sec-WebSocket-Accept, got=%s", accept)
+	}
+	if protocol := res.Header.Get("Sec-WebSocket-Protocol"); protocol != "chat" {
+		t.Errorf("Wrong subprotocol, got=%s", protocol)
+	}
+
+	wsWriteFrame(conn, opPing, []byte("hi"))
+	if opcode, payload := wsReadFrame(t, reader); opcode != opPong || string(payload) != "hi" {
+		t.Errorf("Should answer the ping with pong, got=%d %q", opcode, payload)
+	}
+	wsWriteFrame(conn, WebSocketText, []byte("hello"))
+	if opcode, payload := wsReadFrame(t, reader); opcode != WebSocketText || string(payload) != "echo: hello" {
+		t.Errorf("Wrong echo message, got=%d %q", opcode, payload)
+	}
+	wsWriteFrame(conn, WebSocketBinary, []byte("a message too big to be accepted"))
+	opcode, payload := wsReadFrame(t, reader)
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != WebSocketCloseTooBig {
+		t.Errorf("Should close the connection for the big message, got=%d %q", opcode, payload)
+	}
+	select {
+	case status := <-statuses:
+		if status != http.StatusSwitchingProtocols {
+			t.Errorf("Wrong status for the middleware, expected=101, got=%d", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("The handler should return after the connection closed")
+	}
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/mijia/sweb/server"
+)
+
+// CookieStore keeps the sessions in the cookies encoded by the server.SecureCookie, i.e. signed or encrypted with
+// the rotating keys. Since the clients keep the data, the concurrent changes are not merged and the sessions can
+// not be revoked before the expiry. The values are bound to the cookie name of the SessionWare using the store.
+type CookieStore struct {
+	codec *server.SecureCookie
+	name  string
+}
+
+// NewCookieStore returns a new CookieStore with the SecureCookie encoding the sessions.
+func NewCookieStore(codec *server.SecureCookie) *CookieStore {
+	return &CookieStore{codec: codec, name: kCookieName}
+}
+
+// Load implements the Store interface.
+func (s *CookieStore) Load(value string) ([]byte, error) {
+	payload, err := s.codec.Decode(s.name, value)
+	if err != nil || len(payload) < 8 {
+		return nil, nil
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if time.Now().After(expires) {
+		return nil, nil
+	}
+	return payload[8:], nil
+}
+
+// Save implements the Store interface, returns the encoded cookie value.
+func (s *CookieStore) Save(id string, data []byte, expires time.Time) (string, error) {
+	payload := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	return s.codec.Encode(s.name, append(payload, data...))
+}
+
+// Delete implements the Store interface, nothing to delete since the data is in the cookie.
+func (s *CookieStore) Delete(id string) error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// FileStore keeps the sessions as the files in the directory, the first line of the file is the expiry.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a new FileStore, the directory would be created if not exists.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir}, nil
+}
+
+// Load implements the Store interface.
+func (s *FileStore) Load(value string) ([]byte, error) {
+	if !validSessionId(value) {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(s.path(value))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	expires, data, ok := parseSessionFile(content)
+	if !ok || time.Now().Unix() > expires {
+		os.Remove(s.path(value))
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Save implements the Store interface, the file is written atomically.
+func (s *FileStore) Save(id string, data []byte, expires time.Time) (string, error) {
+	if !validSessionId(id) {
+		return "", ErrInvalidSession
+	}
+	file, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	content := append([]byte(strconv.FormatInt(expires.Unix(), 10)+"\n"), data...)
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := os.Rename(file.Name(), s.path(id)); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete implements the Store interface.
+func (s *FileStore) Delete(id string) error {
+	if !validSessionId(id) {
+		return nil
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the expired session files, should be called periodically.
+func (s *FileStore) Cleanup() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, info := range files {
+		if !validSessionId(info.Name()) {
+			continue
+		}
+		content, err := ioutil.ReadFile(s.path(info.Name()))
+		if err != nil {
+			continue
+		}
+		if expires, _, ok := parseSessionFile(content); !ok || now > expires {
+			os.Remove(s.path(info.Name()))
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func parseSessionFile(content []byte) (int64, []byte, bool) {
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return 0, nil, false
+	}
+	expires, err := strconv.ParseInt(string(content[:i]), 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	return expires, content[i+1:], true
+}
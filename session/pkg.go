@@ -0,0 +1,29 @@
+/*
+Package session provides the session management for the sweb server, the session would be loaded into the request
+context by the SessionWare, and saved back to the Store before the response header is written.
+
+	store := session.NewMemoryStore()
+	srv.Middleware(session.NewSessionWare(store, session.Options{}))
+
+	func Login(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		// rotate the session id on the privilege changes against the session fixation
+		session.Rotate(ctx)
+		session.Set(ctx, "userId", user.Id)
+		session.Flash(ctx, "Welcome back")
+		...
+	}
+
+	func Profile(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		var userId int
+		if !session.Get(ctx, "userId", &userId) {
+			...
+		}
+		messages := session.Flashes(ctx)
+		...
+	}
+
+The stores for the secure cookies, in-memory and filesystem are provided, other backends can implement the Store
+interface. The concurrent requests of the same session would only write their own changes for the server side
+stores, the changes are merged into the latest saved session.
+*/
+package session
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	kSessionKey = "inter_ctx_key_session"
+
+	kSessionIdSize = 32
+)
+
+// Session keeps the values of a client across the requests, the values are json encoded.
+type Session struct {
+	lock        sync.Mutex
+	data        sessionData
+	storedId    string
+	serverSide  bool
+	isNew       bool
+	modified    bool
+	destroyed   bool
+	changes     map[string]json.RawMessage
+	newFlashes  []string
+	readFlashes int
+}
+
+// sessionData is the encoded session in the stores.
+type sessionData struct {
+	Id       string                     `json:"id"`
+	Values   map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes  []string                   `json:"flashes,omitempty"`
+	Created  time.Time                  `json:"created"`
+	Accessed time.Time                  `json:"accessed"`
+}
+
+func newSession() *Session {
+	now := time.Now()
+	return &Session{
+		data: sessionData{
+			Id:       newSessionId(),
+			Values:   make(map[string]json.RawMessage),
+			Created:  now,
+			Accessed: now,
+		},
+		isNew:   true,
+		changes: make(map[string]json.RawMessage),
+	}
+}
+
+// FromContext returns the session of the request, nil if the SessionWare is not used.
+func FromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(kSessionKey).(*Session)
+	return sess
+}
+
+// HasSession tells if the request has a session saved before, e.g. for the server.CacheOptions Bypass, the new
+// sessions created by the SessionWare for the requests are not counted.
+func HasSession(ctx context.Context) bool {
+	sess := FromContext(ctx)
+	return sess != nil && !sess.IsNew()
+}
+
+// Id returns the session id.
+func (s *Session) Id() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data.Id
+}
+
+// IsNew tells if the session is created by this request.
+func (s *Session) IsNew() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.isNew
+}
+
+// Get unmarshals the value of the key into v, returns false if not found.
+func (s *Session) Get(key string, v interface{}) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.data.Values[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// GetString returns the string value of the key, empty if not found.
+func (s *Session) GetString(key string) string {
+	var value string
+	s.Get(key, &value)
+	return value
+}
+
+// Set sets the value of the key, the value should be able to be marshaled as json.
+func (s *Session) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Values[key] = data
+	s.changes[key] = data
+	s.modified = true
+	return nil
+}
+
+// Delete deletes the value of the key.
+func (s *Session) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data.Values, key)
+	s.changes[key] = nil
+	s.modified = true
+}
+
+// Flash adds a flash message which would be read once by the Flashes.
+func (s *Session) Flash(message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Flashes = append(s.data.Flashes, message)
+	s.newFlashes = append(s.newFlashes, message)
+	s.modified = true
+}
+
+// Flashes returns and clears the flash messages.
+func (s *Session) Flashes() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	flashes := s.data.Flashes
+	if len(flashes) == 0 {
+		return nil
+	}
+	s.readFlashes += len(flashes) - len(s.newFlashes)
+	s.newFlashes = nil
+	s.data.Flashes = nil
+	s.modified = true
+	return flashes
+}
+
+// Rotate changes the session id and keeps the values, should be called when the privilege changes, e.g. login.
+func (s *Session) Rotate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Id = newSessionId()
+	s.modified = true
+}
+
+// Destroy clears all the values and the session would be deleted from the store, e.g. logout.
+func (s *Session) Destroy() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.destroyed = true
+	s.data.Values = make(map[string]json.RawMessage)
+	s.data.Flashes = nil
+}
+
+// merge applies the changes of this request onto the latest saved session, should be called with the lock.
+func (s *Session) merge(latest sessionData) {
+	if latest.Values == nil {
+		latest.Values = make(map[string]json.RawMessage)
+	}
+	for key, data := range s.changes {
+		if data == nil {
+			delete(latest.Values, key)
+		} else {
+			latest.Values[key] = data
+		}
+	}
+	if s.readFlashes >= len(latest.Flashes) {
+		latest.Flashes = nil
+	} else {
+		latest.Flashes = latest.Flashes[s.readFlashes:]
+	}
+	latest.Flashes = append(latest.Flashes, s.newFlashes...)
+	latest.Id = s.data.Id
+	latest.Accessed = s.data.Accessed
+	s.data = latest
+}
+
+// Get unmarshals the session value of the key into v, returns false if not found.
+func Get(ctx context.Context, key string, v interface{}) bool {
+	if sess := FromContext(ctx); sess != nil {
+		return sess.Get(key, v)
+	}
+	return false
+}
+
+// Set sets the session value of the key.
+func Set(ctx context.Context, key string, v interface{}) error {
+	if sess := FromContext(ctx); sess != nil {
+		return sess.Set(key, v)
+	}
+	return ErrNoSession
+}
+
+// Delete deletes the session value of the key.
+func Delete(ctx context.Context, key string) {
+	if sess := FromContext(ctx); sess != nil {
+		sess.Delete(key)
+	}
+}
+
+// Flash adds a flash message to the session.
+func Flash(ctx context.Context, message string) {
+	if sess := FromContext(ctx); sess != nil {
+		sess.Flash(message)
+	}
+}
+
+// Flashes returns and clears the flash messages of the session.
+func Flashes(ctx context.Context) []string {
+	if sess := FromContext(ctx); sess != nil {
+		return sess.Flashes()
+	}
+	return nil
+}
+
+// Rotate changes the session id.
+func Rotate(ctx context.Context) {
+	if sess := FromContext(ctx); sess != nil {
+		sess.Rotate()
+	}
+}
+
+// Destroy destroys the session.
+func Destroy(ctx context.Context) {
+	if sess := FromContext(ctx); sess != nil {
+		sess.Destroy()
+	}
+}
+
+func newSessionId() string {
+	id := make([]byte, kSessionIdSize)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// validSessionId tells if the id is generated by newSessionId, so that it is safe for the file names.
+func validSessionId(id string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(data) == kSessionIdSize
+}
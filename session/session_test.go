@@ -0,0 +1,223 @@
+package session
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mijia/sweb/server"
+	"golang.org/x/net/context"
+)
+
+type testClient struct {
+	srv    *server.Server
+	name   string
+	cookie *http.Cookie
+}
+
+func (c *testClient) get(t *testing.T, path string) string {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", path, nil)
+	if c.cookie != nil {
+		r.AddCookie(c.cookie)
+	}
+	c.srv.ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == kCookieName || cookie.Name == c.name {
+			if cookie.MaxAge < 0 {
+				c.cookie = nil
+			} else {
+				c.cookie = cookie
+			}
+		}
+	}
+	return w.Body.String()
+}
+
+func newTestServer(store Store, opt Options) *server.Server {
+	srv := server.New(context.Background(), false)
+	srv.Middleware(NewSessionWare(store, opt))
+	srv.Get("/set/:value", "Set", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		Set(ctx, "value", server.Params(ctx, "value"))
+		Flash(ctx, "saved "+server.Params(ctx, "value"))
+		w.Write([]byte("ok"))
+		return ctx
+	})
+	srv.Get("/get", "Get", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		var value string
+		Get(ctx, "value", &value)
+		w.Write([]byte(value + "|" + strings.Join(Flashes(ctx), ",")))
+		return ctx
+	})
+	srv.Get("/has", "Has", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		if HasSession(ctx) {
+			w.Write([]byte("yes"))
+		}
+		return ctx
+	})
+	srv.Get("/login", "Login", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		Rotate(ctx)
+		Set(ctx, "user", "mijia")
+		return ctx
+	})
+	srv.Get("/logout", "Logout", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		Destroy(ctx)
+		return ctx
+	})
+	srv.Get("/slow/:key", "Slow", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		time.Sleep(10 * time.Millisecond)
+		Set(ctx, server.Params(ctx, "key"), true)
+		return ctx
+	})
+	srv.Get("/keys", "Keys", func(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		var a, b bool
+		if Get(ctx, "a", &a) && Get(ctx, "b", &b) {
+			w.Write([]byte("both"))
+		}
+		return ctx
+	})
+	return srv
+}
+
+func testSessionFlow(t *testing.T, store Store) {
+	client := &testClient{srv: newTestServer(store, Options{})}
+	if body := client.get(t, "/get"); body != "|" || client.cookie != nil {
+		t.Fatalf("Empty new session should not be saved, %q %v", body, client.cookie)
+	}
+	if body := client.get(t, "/has"); body != "" {
+		t.Errorf("New session should not be counted as saved")
+	}
+	client.get(t, "/set/hello")
+	if client.cookie == nil || !client.cookie.HttpOnly {
+		t.Fatalf("Session cookie should be set")
+	}
+	if body := client.get(t, "/has"); body != "yes" {
+		t.Errorf("Saved session should be found")
+	}
+	if body := client.get(t, "/get"); body != "hello|saved hello" {
+		t.Errorf("Session value and flash mismatched, %q", body)
+	}
+	if body := client.get(t, "/get"); body != "hello|" {
+		t.Errorf("Flash should be read once, %q", body)
+	}
+
+	oldCookie := client.cookie
+	client.get(t, "/login")
+	if client.cookie.Value == oldCookie.Value {
+		t.Errorf("Session id should be rotated on login")
+	}
+	if body := client.get(t, "/get"); body != "hello|" {
+		t.Errorf("Rotated session should keep the values, %q", body)
+	}
+	client.get(t, "/logout")
+	if body := client.get(t, "/get"); body != "|" || client.cookie != nil {
+		t.Errorf("Destroyed session should be cleared, %q", body)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testSessionFlow(t, store)
+	if store.Len() != 0 {
+		t.Errorf("Rotated and destroyed sessions should be deleted, %d left", store.Len())
+	}
+
+	client := &testClient{srv: newTestServer(store, Options{})}
+	client.get(t, "/set/x")
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			c := &testClient{srv: client.srv, cookie: client.cookie}
+			c.get(t, "/slow/"+key)
+		}(key)
+	}
+	wg.Wait()
+	if body := client.get(t, "/keys"); body != "both" {
+		t.Errorf("Concurrent changes should be merged, %q", body)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sweb-session")
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSessionFlow(t, store)
+	if data, _ := store.Load("../../etc/passwd"); data != nil {
+		t.Errorf("Invalid session id should not be loaded")
+	}
+	id := newSessionId()
+	store.Save(id, []byte("{}"), time.Now().Add(-time.Second))
+	store.Cleanup()
+	if _, err := os.Stat(store.path(id)); !os.IsNotExist(err) {
+		t.Errorf("Expired session file should be cleaned up")
+	}
+}
+
+func TestCookieStore(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		codec, err := server.NewSecureCookie(server.SecureCookieOptions{Encrypt: encrypt},
+			[]byte("0123456789abcdef"), []byte("fedcba9876543210"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSessionFlow(t, NewCookieStore(codec))
+	}
+
+	oldCodec, _ := server.NewSecureCookie(server.SecureCookieOptions{}, []byte("old key"))
+	value, _ := NewCookieStore(oldCodec).Save("id", []byte(`{"id":"id"}`), time.Now().Add(time.Minute))
+	codec, _ := server.NewSecureCookie(server.SecureCookieOptions{}, []byte("new key"), []byte("old key"))
+	store := NewCookieStore(codec)
+	if data, _ := store.Load(value); string(data) != `{"id":"id"}` {
+		t.Errorf("Cookie signed by the old key should be loaded, %q", data)
+	}
+	if data, _ := store.Load(value[:len(value)-2] + "xx"); data != nil {
+		t.Errorf("Tampered cookie should not be loaded")
+	}
+	expired, _ := store.Save("id", []byte(`{}`), time.Now().Add(-time.Minute))
+	if data, _ := store.Load(expired); data != nil {
+		t.Errorf("Expired cookie should not be loaded")
+	}
+
+	NewSessionWare(store, Options{CookieName: "other"})
+	if data, _ := store.Load(value); data != nil {
+		t.Errorf("Cookie of the other name should not be loaded")
+	}
+	client := &testClient{srv: newTestServer(store, Options{CookieName: "other"})}
+	client.name = "other"
+	client.get(t, "/set/hello")
+	if client.cookie == nil || !client.cookie.Secure {
+		t.Fatalf("Session cookie should be set with the Secure by default, %v", client.cookie)
+	}
+	if body := client.get(t, "/get"); body != "hello|saved hello" {
+		t.Errorf("Session of the cookie name should be loaded, %q", body)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	client := &testClient{srv: newTestServer(NewMemoryStore(), Options{IdleTimeout: 20 * time.Millisecond})}
+	client.get(t, "/set/v")
+	if body := client.get(t, "/get"); !strings.HasPrefix(body, "v|") {
+		t.Fatalf("Session should be loaded, %q", body)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if body := client.get(t, "/get"); body != "|" {
+		t.Errorf("Idle session should be expired, %q", body)
+	}
+
+	client = &testClient{srv: newTestServer(NewMemoryStore(), Options{AbsoluteTimeout: 20 * time.Millisecond})}
+	client.get(t, "/set/v")
+	time.Sleep(30 * time.Millisecond)
+	if body := client.get(t, "/get"); body != "|" {
+		t.Errorf("Session should be expired after the absolute timeout, %q", body)
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoSession is returned if the SessionWare is not used for the request.
+	ErrNoSession = errors.New("session: no session in the context")
+	// ErrInvalidSession is returned by the stores if the cookie value is not valid.
+	ErrInvalidSession = errors.New("session: invalid session")
+)
+
+// Store loads and saves the encoded sessions. The stores keeping the sessions on the server side should return the
+// session id as the cookie value, so that the concurrent changes of the same session would be merged.
+type Store interface {
+	// Load returns the encoded session by the cookie value, nil if not found or expired.
+	Load(value string) ([]byte, error)
+	// Save saves the encoded session of the id which would be expired at the time, returns the cookie value.
+	Save(id string, data []byte, expires time.Time) (string, error)
+	// Delete deletes the session of the id.
+	Delete(id string) error
+}
+
+// MemoryStore keeps the sessions in memory, the expired ones would be swept periodically.
+type MemoryStore struct {
+	lock      sync.RWMutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  make(map[string]memorySession),
+		lastSweep: time.Now(),
+	}
+}
+
+// Load implements the Store interface.
+func (s *MemoryStore) Load(value string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	sess, ok := s.sessions[value]
+	if !ok || time.Now().After(sess.expires) {
+		return nil, nil
+	}
+	return sess.data, nil
+}
+
+// Save implements the Store interface.
+func (s *MemoryStore) Save(id string, data []byte, expires time.Time) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for key, sess := range s.sessions {
+			if now.After(sess.expires) {
+				delete(s.sessions, key)
+			}
+		}
+	}
+	s.sessions[id] = memorySession{data, expires}
+	return id, nil
+}
+
+// Delete implements the Store interface.
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the count of the sessions.
+func (s *MemoryStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.sessions)
+}
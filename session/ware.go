@@ -0,0 +1,205 @@
+package session
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mijia/sweb/log"
+	"github.com/mijia/sweb/server"
+	"golang.org/x/net/context"
+)
+
+const (
+	kCookieName      = "sweb_session"
+	kIdleTimeout     = 30 * time.Minute
+	kAbsoluteTimeout = 24 * time.Hour
+	kTouchInterval   = time.Minute
+	kLockStripes     = 64
+)
+
+// Options defines the options of the SessionWare.
+type Options struct {
+	// The session cookie name, default is "sweb_session"
+	CookieName string
+	// The cookie path, default is "/"
+	Path string
+	// The cookie domain
+	Domain string
+	// The cookie is Secure by default, set Insecure for the plain http, e.g. in development
+	Insecure bool
+	// The session expires if not accessed for this long, default is 30m
+	IdleTimeout time.Duration
+	// The session expires after this long since created regardless of the access, default is 24h
+	AbsoluteTimeout time.Duration
+}
+
+// SessionWare is the middleware loading the session into the request context, the session would be saved before
+// the response header is written if it is modified, or touched to keep it from the idle expiry. The new sessions
+// without any values are not saved.
+type SessionWare struct {
+	store Store
+	opt   Options
+	locks [kLockStripes]sync.Mutex
+}
+
+// ServeHTTP implements the server.Middleware interface.
+func (m *SessionWare) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request, next server.Handler) context.Context {
+	sess := m.load(r)
+	ctx = context.WithValue(ctx, kSessionKey, sess)
+	saved := false
+	save := func() {
+		if !saved {
+			saved = true
+			m.save(w, sess)
+		}
+	}
+	res, ok := w.(server.ResponseWriter)
+	if ok {
+		res.Before(func(server.ResponseWriter) {
+			save()
+		})
+	}
+	newCtx := next(ctx, w, r)
+	if !ok || !res.Written() {
+		save()
+	}
+	return newCtx
+}
+
+// NewSessionWare returns a new SessionWare with the store, the CookieStore would encode the sessions by the cookie name.
+func NewSessionWare(store Store, opt Options) server.Middleware {
+	if opt.CookieName == "" {
+		opt.CookieName = kCookieName
+	}
+	if opt.Path == "" {
+		opt.Path = "/"
+	}
+	if opt.IdleTimeout <= 0 {
+		opt.IdleTimeout = kIdleTimeout
+	}
+	if opt.AbsoluteTimeout <= 0 {
+		opt.AbsoluteTimeout = kAbsoluteTimeout
+	}
+	if cookieStore, ok := store.(*CookieStore); ok {
+		cookieStore.name = opt.CookieName
+	}
+	return &SessionWare{
+		store: store,
+		opt:   opt,
+	}
+}
+
+func (m *SessionWare) load(r *http.Request) *Session {
+	cookie, err := r.Cookie(m.opt.CookieName)
+	if err != nil {
+		return newSession()
+	}
+	sess, err := m.loadData(cookie.Value)
+	if err != nil {
+		log.Warnf("[SessionWare] Failed to load the session, %s", err)
+	}
+	if sess == nil {
+		return newSession()
+	}
+	if m.expired(sess.data, time.Now()) {
+		m.store.Delete(sess.data.Id)
+		return newSession()
+	}
+	sess.storedId = sess.data.Id
+	sess.serverSide = cookie.Value == sess.data.Id
+	return sess
+}
+
+func (m *SessionWare) loadData(value string) (*Session, error) {
+	data, err := m.store.Load(value)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	sess := &Session{changes: make(map[string]json.RawMessage)}
+	if err := json.Unmarshal(data, &sess.data); err != nil {
+		return nil, err
+	}
+	if sess.data.Values == nil {
+		sess.data.Values = make(map[string]json.RawMessage)
+	}
+	return sess, nil
+}
+
+func (m *SessionWare) expired(data sessionData, now time.Time) bool {
+	return now.Sub(data.Created) > m.opt.AbsoluteTimeout || now.Sub(data.Accessed) > m.opt.IdleTimeout
+}
+
+func (m *SessionWare) save(w http.ResponseWriter, sess *Session) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.destroyed {
+		if sess.storedId != "" {
+			if err := m.store.Delete(sess.storedId); err != nil {
+				log.Warnf("[SessionWare] Failed to delete the session, %s", err)
+			}
+			m.setCookie(w, "", time.Unix(1, 0))
+		}
+		return
+	}
+	now := time.Now()
+	if !sess.modified && (sess.isNew || now.Sub(sess.data.Accessed) < kTouchInterval) {
+		return
+	}
+	sess.data.Accessed = now
+
+	if sess.serverSide {
+		// merge the changes into the latest saved session, since the concurrent requests may have saved it
+		lock := &m.locks[lockStripe(sess.storedId)]
+		lock.Lock()
+		defer lock.Unlock()
+		if latest, err := m.loadData(sess.storedId); err == nil && latest != nil {
+			sess.merge(latest.data)
+		}
+	}
+	data, err := json.Marshal(sess.data)
+	if err != nil {
+		log.Errorf("[SessionWare] Failed to encode the session, %s", err)
+		return
+	}
+	expires := sess.data.Created.Add(m.opt.AbsoluteTimeout)
+	storeExpires := now.Add(m.opt.IdleTimeout)
+	if storeExpires.After(expires) {
+		storeExpires = expires
+	}
+	value, err := m.store.Save(sess.data.Id, data, storeExpires)
+	if err != nil {
+		log.Errorf("[SessionWare] Failed to save the session, %s", err)
+		return
+	}
+	if sess.storedId != "" && sess.storedId != sess.data.Id {
+		m.store.Delete(sess.storedId)
+	}
+	m.setCookie(w, value, expires)
+}
+
+func (m *SessionWare) setCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     m.opt.CookieName,
+		Value:    value,
+		Path:     m.opt.Path,
+		Domain:   m.opt.Domain,
+		Expires:  expires,
+		Secure:   !m.opt.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+	w.Header().Add("Vary", "Cookie")
+}
+
+func lockStripe(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32() % kLockStripes
+}